@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/wwmoraes/docker-engine-plugins/internal/common"
+)
+
+const (
+	// EnvCredentialsPath is the credential files directory environment variable name
+	EnvCredentialsPath string = `CREDENTIALS_PATH`
+	// EnvSecretsPath is the secret files directory environment variable name
+	EnvSecretsPath string = `SECRETS_PATH`
+	// EnvMountRoot is the volume mount points root environment variable name
+	EnvMountRoot string = `MOUNT_ROOT`
+	// EnvDefaultOptions is the default mount options environment variable name
+	EnvDefaultOptions string = `DEFAULT_OPTIONS`
+	// EnvAllowedSensitiveOptions is the sensitive mount options allowed on
+	// volumes environment variable name
+	EnvAllowedSensitiveOptions string = `ALLOWED_SENSITIVE_OPTIONS`
+	// EnvMounter is the mount implementation environment variable name
+	EnvMounter string = `MOUNTER`
+	// EnvStatePath is the plugin state directory environment variable name
+	EnvStatePath string = `STATE_PATH`
+	// EnvDatabaseTimeout is the database lock wait environment variable name
+	EnvDatabaseTimeout string = `DATABASE_TIMEOUT`
+	// EnvReadOnly is the read-only database mode environment variable name
+	EnvReadOnly string = `READ_ONLY`
+	// EnvUnmountOnShutdown is the unmount volumes on shutdown environment
+	// variable name
+	EnvUnmountOnShutdown string = `UNMOUNT_ON_SHUTDOWN`
+)
+
+const (
+	// DefaultDatabaseName is the volume state database file name within the
+	// state directory
+	DefaultDatabaseName string = `cifs.db`
+	// DefaultStatePath is the persisted state mount set on the plugin config.json
+	DefaultStatePath string = `/var/lib/cifs-volume-plugin`
+	// DefaultDatabaseTimeout is how long to wait for another instance to
+	// release the database lock
+	DefaultDatabaseTimeout time.Duration = 5 * time.Second
+	// DefaultSecretsPath is the secrets mount set on the plugin config.json
+	DefaultSecretsPath string = `/run/cifs-secrets`
+	// DefaultMountRoot is the propagated mount set on the plugin config.json
+	DefaultMountRoot string = `/var/lib/docker-volumes`
+)
+
+// config contains all settings used by the main application
+type config struct {
+	CredentialsPath string
+	SecretsPath     string
+	MountRoot       string
+	DatabasePath    string
+	DatabaseTimeout time.Duration
+	// ReadOnly opens the database without write access, refusing state changes
+	ReadOnly                bool
+	DefaultOptions          string
+	AllowedSensitiveOptions string
+	Mounter                 string
+	// UnmountOnShutdown releases all mounted volumes when the plugin stops
+	UnmountOnShutdown bool
+}
+
+// newConfig loads settings from the environment
+func newConfig() (*config, error) {
+	credentialsPath, err := getCredentialsPath()
+	if err != nil {
+		return nil, err
+	}
+
+	databaseTimeout, err := time.ParseDuration(common.GetEnvDefault(EnvDatabaseTimeout, DefaultDatabaseTimeout.String()))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", EnvDatabaseTimeout, err)
+	}
+
+	if databaseTimeout <= 0 {
+		return nil, fmt.Errorf("invalid %s: must be positive", EnvDatabaseTimeout)
+	}
+
+	readOnly, err := strconv.ParseBool(common.GetEnvDefault(EnvReadOnly, "false"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", EnvReadOnly, err)
+	}
+
+	unmountOnShutdown, err := strconv.ParseBool(common.GetEnvDefault(EnvUnmountOnShutdown, "false"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", EnvUnmountOnShutdown, err)
+	}
+
+	return &config{
+		CredentialsPath:         credentialsPath,
+		SecretsPath:             common.GetEnvDefault(EnvSecretsPath, DefaultSecretsPath),
+		MountRoot:               common.GetEnvDefault(EnvMountRoot, DefaultMountRoot),
+		DatabasePath:            path.Join(common.GetEnvDefault(EnvStatePath, DefaultStatePath), DefaultDatabaseName),
+		DatabaseTimeout:         databaseTimeout,
+		ReadOnly:                readOnly,
+		DefaultOptions:          os.Getenv(EnvDefaultOptions),
+		AllowedSensitiveOptions: os.Getenv(EnvAllowedSensitiveOptions),
+		Mounter:                 os.Getenv(EnvMounter),
+		UnmountOnShutdown:       unmountOnShutdown,
+	}, nil
+}
+
+func getCredentialsPath() (string, error) {
+	credentialsPath := os.Getenv(EnvCredentialsPath)
+	if len(credentialsPath) == 0 {
+		return "", fmt.Errorf("credentials path must not be empty")
+	}
+
+	info, err := os.Stat(credentialsPath)
+	if err != nil {
+		return "", err
+	}
+
+	if !info.IsDir() {
+		return "", fmt.Errorf("credentials path is not a directory")
+	}
+
+	if (info.Mode().Perm()&0500) == 0 && (info.Mode().Perm()&0050) == 0 {
+		return "", &common.PermissionError{Err: fmt.Errorf("driver has no access to credentials")}
+	}
+
+	return credentialsPath, nil
+}
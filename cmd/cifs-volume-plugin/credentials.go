@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+// CredentialsSourceOption is the volume option that names where to read the
+// share credentials from
+const CredentialsSourceOption string = `credentials-source`
+
+const (
+	// SourceSecret reads credentials from a secret file by name
+	SourceSecret string = "secret"
+	// SourceFile reads credentials from a file relative to the credentials path
+	SourceFile string = "file"
+)
+
+// Credentials authenticate a share mount
+type Credentials struct {
+	Username string
+	Password string
+	Domain   string
+}
+
+// String describes the credentials without the password
+func (credentials *Credentials) String() string {
+	if credentials.Domain == "" {
+		return credentials.Username
+	}
+
+	return fmt.Sprintf(`%s\%s`, credentials.Domain, credentials.Username)
+}
+
+// GoString describes the credentials without the password
+func (credentials *Credentials) GoString() string {
+	return fmt.Sprintf("&Credentials{Username:%q, Password:<redacted>, Domain:%q}", credentials.Username, credentials.Domain)
+}
+
+// MarshalText encodes the credentials using the mount.cifs credentials file
+// format
+func (credentials *Credentials) MarshalText() ([]byte, error) {
+	var data bytes.Buffer
+
+	for _, entry := range [][2]string{
+		{"username", credentials.Username},
+		{"password", credentials.Password},
+		{"domain", credentials.Domain},
+	} {
+		if entry[1] == "" {
+			continue
+		}
+
+		if strings.ContainsAny(entry[1], "\r\n") {
+			return nil, fmt.Errorf("credentials %s must not contain line breaks", entry[0])
+		}
+
+		fmt.Fprintf(&data, "%s=%s\n", entry[0], entry[1])
+	}
+
+	return data.Bytes(), nil
+}
+
+// UnmarshalText decodes either a mount.cifs credentials file or a single line
+// with the password only
+func (credentials *Credentials) UnmarshalText(text []byte) error {
+	lines := strings.Split(strings.TrimRight(string(text), "\r\n"), "\n")
+	if len(lines) == 1 && !strings.Contains(lines[0], "=") {
+		credentials.Password = strings.TrimRight(lines[0], "\r")
+		return nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		switch strings.TrimSpace(parts[0]) {
+		case "username", "user":
+			credentials.Username = parts[1]
+		case "password", "pass":
+			credentials.Password = parts[1]
+		case "domain", "dom":
+			credentials.Domain = parts[1]
+		}
+	}
+
+	return scanner.Err()
+}
+
+// merge fills the unset fields with the ones from other credentials
+func (credentials *Credentials) merge(other *Credentials) {
+	if credentials.Username == "" {
+		credentials.Username = other.Username
+	}
+
+	if credentials.Password == "" {
+		credentials.Password = other.Password
+	}
+
+	if credentials.Domain == "" {
+		credentials.Domain = other.Domain
+	}
+}
+
+// parseCredentialsSource splits a scheme:name credentials source and checks
+// if the name is valid for the scheme
+func parseCredentialsSource(source string) (string, string, error) {
+	parts := strings.SplitN(source, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid credentials source %q: must be scheme:name", source)
+	}
+
+	scheme, name := parts[0], parts[1]
+
+	switch scheme {
+	case SourceSecret:
+		if name != path.Base(name) || name == "." || name == ".." {
+			return "", "", fmt.Errorf("invalid credentials source %q: secret name must not be a path", source)
+		}
+	case SourceFile:
+		err := checkCredentialsFileName(name)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid credentials source %q: %w", source, err)
+		}
+	default:
+		return "", "", fmt.Errorf("invalid credentials source %q: unknown scheme %q", source, scheme)
+	}
+
+	return scheme, name, nil
+}
+
+// checkCredentialsFileName rejects file names outside the credentials path
+func checkCredentialsFileName(name string) error {
+	cleaned := path.Clean(name)
+	if path.IsAbs(cleaned) || cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return fmt.Errorf("file must be relative to the credentials path")
+	}
+
+	return nil
+}
+
+// checkCredentialsOption rejects credentials and cred options pointing outside
+// the credentials path
+func checkCredentialsOption(options Options) error {
+	for _, key := range []string{"credentials", "cred"} {
+		name, exists := options[key]
+		if !exists {
+			continue
+		}
+
+		err := checkCredentialsFileName(name)
+		if err != nil {
+			return fmt.Errorf("invalid mount option %q: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+// checkCredentialsSource rejects credentials sources unless allowed on the
+// plugin settings, as those can read the credentials of any host
+func checkCredentialsSource(source string, allowed map[string]bool) error {
+	if !allowed[CredentialsSourceOption] {
+		return fmt.Errorf("mount option %q is not allowed on volumes", CredentialsSourceOption)
+	}
+
+	_, _, err := parseCredentialsSource(source)
+	return err
+}
+
+// readCredentialsSource loads the credentials a source points to
+func (driver *cifsDriver) readCredentialsSource(source string) (*Credentials, error) {
+	scheme, name, err := parseCredentialsSource(source)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+
+	switch scheme {
+	case SourceSecret:
+		data, err = readRegularFile(path.Join(driver.secretsPath, name))
+	case SourceFile:
+		data, err = readRegularFile(path.Join(driver.credentialsPath, name))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials from %s: %w", source, err)
+	}
+
+	var credentials Credentials
+	err = credentials.UnmarshalText(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse credentials from %s: %w", source, err)
+	}
+
+	return &credentials, nil
+}
+
+func readRegularFile(name string) ([]byte, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a file", name)
+	}
+
+	return os.ReadFile(name)
+}
+
+// findCredentialsFile looks for the credential file of the share, falling back
+// to the parent paths up to the host one. It returns an empty name if none
+// exists
+func (driver *cifsDriver) findCredentialsFile(unc *UNC) (string, error) {
+	segments := unc.Segments()
+	for index := range segments {
+		fileName := strings.Join(segments[:len(segments)-index], `%2F`)
+		credentialsFile := path.Join(driver.credentialsPath, fileName)
+		info, err := os.Stat(credentialsFile)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+
+		return credentialsFile, nil
+	}
+
+	return "", nil
+}
+
+// getCredentials resolves the credentials of a volume, in order: the
+// per-volume username and domain options, the credentials source or file
+// option, and the share credential file. It returns nil for anonymous mounts
+func (driver *cifsDriver) getCredentials(status Status) (*Credentials, error) {
+	credentials := &Credentials{
+		Username: firstOption(status.Options, "username", "user"),
+		Domain:   firstOption(status.Options, "domain", "dom"),
+	}
+
+	var err error
+	var stored *Credentials
+
+	switch {
+	case status.CredentialsSource != "":
+		stored, err = driver.readCredentialsSource(status.CredentialsSource)
+	case firstOption(status.Options, "credentials", "cred") != "":
+		err = checkCredentialsOption(status.Options)
+		if err == nil {
+			stored, err = readCredentialsFile(path.Join(driver.credentialsPath, firstOption(status.Options, "credentials", "cred")))
+		}
+	default:
+		var unc *UNC
+		unc, err = ParseUNC(status.Service)
+		if err != nil {
+			return nil, err
+		}
+
+		var credentialsFile string
+		credentialsFile, err = driver.findCredentialsFile(unc)
+		if err == nil && credentialsFile != "" {
+			stored, err = readCredentialsFile(credentialsFile)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if stored != nil {
+		credentials.merge(stored)
+	}
+
+	if *credentials == (Credentials{}) {
+		return nil, nil
+	}
+
+	return credentials, nil
+}
+
+func readCredentialsFile(name string) (*Credentials, error) {
+	data, err := readRegularFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials: %w", err)
+	}
+
+	var credentials Credentials
+	err = credentials.UnmarshalText(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse credentials: %w", err)
+	}
+
+	return &credentials, nil
+}
+
+// firstOption returns the value of the first key set on the options
+func firstOption(options Options, keys ...string) string {
+	for _, key := range keys {
+		if value, exists := options[key]; exists {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// mountOptions removes the credential options, as those are passed to the
+// mounter separately
+func mountOptions(options Options) Options {
+	result := make(Options, len(options))
+
+	for key, value := range options {
+		if sensitiveOptions[key] {
+			continue
+		}
+
+		result[key] = value
+	}
+
+	return result
+}
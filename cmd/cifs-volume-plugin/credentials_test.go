@@ -0,0 +1,339 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestCredentials_UnmarshalText(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want Credentials
+	}{
+		{
+			name: "credentials file",
+			data: "# comment\nusername=foo\npassword=se=cret\ndomain=WORKGROUP\n",
+			want: Credentials{Username: "foo", Password: "se=cret", Domain: "WORKGROUP"},
+		},
+		{
+			name: "credentials file aliases",
+			data: "user=foo\npass=secret\ndom=WORKGROUP",
+			want: Credentials{Username: "foo", Password: "secret", Domain: "WORKGROUP"},
+		},
+		{
+			name: "password only",
+			data: "secret\n",
+			want: Credentials{Password: "secret"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Credentials
+			if err := got.UnmarshalText([]byte(tt.data)); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Credentials.UnmarshalText() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCredentials_redacted(t *testing.T) {
+	credentials := &Credentials{Username: "foo", Password: "secret", Domain: "WORKGROUP"}
+
+	for _, format := range []string{"%s", "%v", "%+v", "%#v"} {
+		got := fmt.Sprintf(format, credentials)
+		if got == "" || strings.Contains(got, credentials.Password) {
+			t.Errorf("fmt.Sprintf(%q) = %q, want the password redacted", format, got)
+		}
+	}
+}
+
+func Test_parseCredentialsSource(t *testing.T) {
+	tests := []struct {
+		name       string
+		source     string
+		wantScheme string
+		wantName   string
+		wantErr    bool
+	}{
+		{
+			name:       "secret",
+			source:     "secret:cifs-foo",
+			wantScheme: SourceSecret,
+			wantName:   "cifs-foo",
+		},
+		{
+			name:       "file",
+			source:     "file:hosts/foo",
+			wantScheme: SourceFile,
+			wantName:   "hosts/foo",
+		},
+		{
+			name:    "missing scheme",
+			source:  "cifs-foo",
+			wantErr: true,
+		},
+		{
+			name:    "unknown scheme",
+			source:  "vault:cifs-foo",
+			wantErr: true,
+		},
+		{
+			name:    "secret path",
+			source:  "secret:../etc/shadow",
+			wantErr: true,
+		},
+		{
+			name:    "absolute file",
+			source:  "file:/etc/shadow",
+			wantErr: true,
+		},
+		{
+			name:    "file outside credentials path",
+			source:  "file:foo/../../etc/shadow",
+			wantErr: true,
+		},
+		{
+			name:    "empty name",
+			source:  "secret:",
+			wantErr: true,
+		},
+		{
+			name:    "environment variable",
+			source:  "env:CIFS_FOO",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme, name, err := parseCredentialsSource(tt.source)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCredentialsSource() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if scheme != tt.wantScheme || name != tt.wantName {
+				t.Errorf("parseCredentialsSource() = %s, %s, want %s, %s", scheme, name, tt.wantScheme, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestCifsDriver_getCredentials(t *testing.T) {
+	dir := t.TempDir()
+	driver := newTestDriver(t, dir, &fakeMounter{}, fakeMountTable{})
+	driver.secretsPath = path.Join(dir, "secrets")
+
+	files := map[string]string{
+		path.Join(driver.credentialsPath, "host"):             "username=admin\npassword=admin-secret\n",
+		path.Join(driver.credentialsPath, "host%2Ffoo"):       "username=foo\npassword=foo-secret\n",
+		path.Join(driver.credentialsPath, "volumes", "bar"):   "bar-secret\n",
+		path.Join(driver.secretsPath, "cifs-baz"):             "username=baz\npassword=baz-secret\ndomain=CORP\n",
+		path.Join(driver.credentialsPath, "explicit-qux"):     "username=qux\npassword=qux-secret\n",
+		path.Join(driver.credentialsPath, "host%2Fdir%2Fsub"): "password=sub-secret\n",
+	}
+	for name, content := range files {
+		err := os.MkdirAll(path.Dir(name), 0700)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = os.WriteFile(name, []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		status  Status
+		want    *Credentials
+		wantErr bool
+	}{
+		{
+			name:   "share credential file",
+			status: Status{Service: "//host/foo"},
+			want:   &Credentials{Username: "foo", Password: "foo-secret"},
+		},
+		{
+			name:   "share path credential file",
+			status: Status{Service: "//host/dir/sub"},
+			want:   &Credentials{Password: "sub-secret"},
+		},
+		{
+			name:   "host credential file",
+			status: Status{Service: "//host/other"},
+			want:   &Credentials{Username: "admin", Password: "admin-secret"},
+		},
+		{
+			name:   "anonymous",
+			status: Status{Service: "//another-host/foo"},
+			want:   nil,
+		},
+		{
+			name: "volume username with password file",
+			status: Status{
+				Service:           "//host/bar",
+				Options:           Options{"username": "bar", "domain": "CORP"},
+				CredentialsSource: "file:volumes/bar",
+			},
+			want: &Credentials{Username: "bar", Password: "bar-secret", Domain: "CORP"},
+		},
+		{
+			name: "secret",
+			status: Status{
+				Service:           "//host/baz",
+				CredentialsSource: "secret:cifs-baz",
+			},
+			want: &Credentials{Username: "baz", Password: "baz-secret", Domain: "CORP"},
+		},
+		{
+			name: "credentials option",
+			status: Status{
+				Service: "//host/qux",
+				Options: Options{"credentials": "explicit-qux"},
+			},
+			want: &Credentials{Username: "qux", Password: "qux-secret"},
+		},
+		{
+			name: "absolute credentials option",
+			status: Status{
+				Service: "//host/qux",
+				Options: Options{"credentials": path.Join(driver.credentialsPath, "explicit-qux")},
+			},
+			wantErr: true,
+		},
+		{
+			name: "credentials option outside credentials path",
+			status: Status{
+				Service: "//host/qux",
+				Options: Options{"cred": "../credentials/explicit-qux"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing secret",
+			status: Status{
+				Service:           "//host/baz",
+				CredentialsSource: "secret:missing",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := driver.getCredentials(tt.status)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("cifsDriver.getCredentials() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("cifsDriver.getCredentials() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCifsDriver_credentialsSource(t *testing.T) {
+	dir := t.TempDir()
+	mounter := &fakeMounter{}
+	driver := newTestDriver(t, dir, mounter, fakeMountTable{})
+	driver.allowedOptions = map[string]bool{"username": true}
+
+	err := os.WriteFile(path.Join(driver.credentialsPath, "foo-password"), []byte("foo-secret\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = driver.Create(&volume.CreateRequest{
+		Name: "foo",
+		Options: map[string]string{
+			"share":                 "//host/foo",
+			CredentialsSourceOption: "file:foo-password",
+		},
+	})
+	if err == nil {
+		t.Errorf("cifsDriver.Create() error = nil, want error on a credentials source not allowed")
+	}
+
+	driver.allowedOptions[CredentialsSourceOption] = true
+
+	err = driver.Create(&volume.CreateRequest{
+		Name: "foo",
+		Options: map[string]string{
+			"share":                 "//host/foo",
+			"username":              "foo",
+			"vers":                  "3.0",
+			CredentialsSourceOption: "file:foo-password",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = driver.Create(&volume.CreateRequest{
+		Name: "bar",
+		Options: map[string]string{
+			"share":    "//host/bar",
+			"password": "plaintext",
+		},
+	})
+	if err == nil {
+		t.Errorf("cifsDriver.Create() error = nil, want error on a plaintext password")
+	}
+
+	err = driver.Create(&volume.CreateRequest{
+		Name: "bar",
+		Options: map[string]string{
+			"share":                 "//host/bar",
+			CredentialsSourceOption: "file:/etc/shadow",
+		},
+	})
+	if err == nil {
+		t.Errorf("cifsDriver.Create() error = nil, want error on an invalid credentials source")
+	}
+
+	_, err = driver.Mount(&volume.MountRequest{Name: "foo", ID: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantCall := mounterCall{
+		Op:          "mount",
+		Source:      "//host/foo",
+		Options:     Options{"vers": "3.0"},
+		Credentials: &Credentials{Username: "foo", Password: "foo-secret"},
+	}
+	gotCall := mounter.calls[0]
+	gotCall.Target = ""
+	if !reflect.DeepEqual(gotCall, wantCall) {
+		t.Errorf("mounter call = %#+v, want %#+v", gotCall, wantCall)
+	}
+
+	raw, err := os.ReadFile(path.Join(dir, "cifs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(raw), "foo-secret") {
+		t.Errorf("database contains the plaintext password")
+	}
+
+	err = driver.Unmount(&volume.UnmountRequest{Name: "foo", ID: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// volumes created while the plugin settings allowed credentials sources
+	delete(driver.allowedOptions, CredentialsSourceOption)
+
+	_, err = driver.Mount(&volume.MountRequest{Name: "foo", ID: "b"})
+	if err == nil {
+		t.Errorf("cifsDriver.Mount() error = nil, want error on a credentials source not allowed")
+	}
+}
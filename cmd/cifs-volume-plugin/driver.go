@@ -1,31 +1,92 @@
 package main
 
 import (
-	"bytes"
-	"encoding/gob"
+	"context"
 	"errors"
 	"fmt"
 	"io/fs"
 	"os"
-	"os/exec"
 	"path"
+	"reflect"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/go-plugins-helpers/volume"
-	"github.com/mitchellh/mapstructure"
+	"github.com/wwmoraes/docker-engine-plugins/internal/common"
+	"github.com/wwmoraes/docker-engine-plugins/internal/mount"
 	bolt "go.etcd.io/bbolt"
 )
 
-var volumeBucket = []byte("volumes")
+// mountpointMode is the permission set of the volume mount point directories
+const mountpointMode = 0755
+
+// stateMode is the permission set of the database directory
+const stateMode = 0750
+
+// ErrReadOnly is returned on volume changes when the database is read-only
+var ErrReadOnly = errors.New("volume database is read-only")
 
 type cifsDriver struct {
 	db              *bolt.DB
 	credentialsPath string
+	secretsPath     string
+	mountRoot       string
+	readOnly        bool
+	defaultOptions  Options
+	// allowedOptions contains the sensitive options accepted on volumes
+	allowedOptions map[string]bool
+	mounter        Mounter
+	logger         *common.Logger
+	// now returns the current time, used on volume timestamps
+	now func() time.Time
+	// mu serializes mount state changes, as those read and write the volume
+	// record on separate transactions
+	mu sync.Mutex
 }
 
 type Options map[string]string
 
+// negatePrefix marks a volume option key that removes a default option
+const negatePrefix = "!"
+
+// mergeOptions applies the volume options over the defaults. Volume values win,
+// and keys prefixed with an exclamation mark remove the default option
+func mergeOptions(defaults Options, options Options) Options {
+	merged := make(Options, len(defaults)+len(options))
+
+	for key, value := range defaults {
+		merged[key] = value
+	}
+
+	for key, value := range options {
+		if strings.HasPrefix(key, negatePrefix) {
+			delete(merged, strings.TrimPrefix(key, negatePrefix))
+			continue
+		}
+
+		merged[key] = value
+	}
+
+	return merged
+}
+
+// volumeOptions returns the options a volume sets on top of the defaults, as
+// the defaults set by the operator need no allowing
+func volumeOptions(defaults Options, options Options) Options {
+	own := make(Options, len(options))
+
+	for key, value := range options {
+		if defaultValue, ok := defaults[key]; ok && defaultValue == value {
+			continue
+		}
+
+		own[key] = value
+	}
+
+	return own
+}
+
 func (options Options) String() string {
 	entries := make([]string, 0, len(options))
 
@@ -40,51 +101,274 @@ func (options Options) String() string {
 	return strings.Join(entries, ",")
 }
 
+// References contains the mount request IDs currently using a volume
+type References map[string]bool
+
 type Status struct {
-	Mounted bool
-	Service string
-	Options Options
+	Mounted bool    `json:"mounted"`
+	Service string  `json:"service"`
+	Options Options `json:"options"`
+	// CredentialsSource points to where the credentials are read from on mount
+	CredentialsSource string     `json:"credentialsSource,omitempty"`
+	References        References `json:"references"`
 }
 
-func NewDriver() (volume.Driver, error) {
-	credentialsPath := os.Getenv("CREDENTIALS_PATH")
-	if len(credentialsPath) == 0 {
-		return nil, fmt.Errorf("credentials path must not be empty")
+// NewDriver creates a CIFS volume driver that stores the volumes state on the
+// configured database, fixing it against the current mount table
+func NewDriver(config *config, mounter Mounter, mounts mountTable, logger *common.Logger) (*cifsDriver, error) {
+	if mounter == nil {
+		return nil, fmt.Errorf("no mounter provided")
+	}
+
+	if logger == nil {
+		return nil, fmt.Errorf("no logger provided")
+	}
+
+	defaultOptions, err := mount.NewMountOptions([]byte(config.DefaultOptions))
+	if err != nil {
+		return nil, &common.ConfigError{Err: fmt.Errorf("failed to parse default options: %w", err)}
+	}
+
+	err = validateOptions(Options(*defaultOptions))
+	if err != nil {
+		return nil, &common.ConfigError{Err: fmt.Errorf("invalid default options: %w", err)}
 	}
 
-	info, err := os.Stat(credentialsPath)
+	err = checkDefaultOptions(Options(*defaultOptions))
+	if err != nil {
+		return nil, &common.ConfigError{Err: fmt.Errorf("invalid default options: %w", err)}
+	}
+
+	err = checkCredentialsOption(Options(*defaultOptions))
+	if err != nil {
+		return nil, &common.ConfigError{Err: fmt.Errorf("invalid default options: %w", err)}
+	}
+
+	allowedOptions, err := parseAllowedOptions(config.AllowedSensitiveOptions)
+	if err != nil {
+		return nil, &common.ConfigError{Err: fmt.Errorf("invalid allowed sensitive options: %w", err)}
+	}
+
+	db, err := openDatabase(config)
 	if err != nil {
 		return nil, err
 	}
 
-	if !info.IsDir() {
-		return nil, fmt.Errorf("credentials path is not a directory")
+	driver := &cifsDriver{
+		db:              db,
+		credentialsPath: config.CredentialsPath,
+		secretsPath:     config.SecretsPath,
+		mountRoot:       config.MountRoot,
+		readOnly:        config.ReadOnly,
+		defaultOptions:  Options(*defaultOptions),
+		allowedOptions:  allowedOptions,
+		mounter:         mounter,
+		logger:          logger,
+		now:             time.Now,
 	}
 
-	if (info.Mode().Perm()&0500) == 0 && (info.Mode().Perm()&0050) == 0 {
-		return nil, fmt.Errorf("driver has no access to credentials")
+	err = driver.migrate()
+	if err != nil {
+		db.Close()
+		return nil, err
 	}
 
-	db, err := bolt.Open("cifs.db", 0640, nil)
+	err = driver.reconcile(mounts)
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
 
-	err = db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists(volumeBucket)
-		return err
+	return driver, nil
+}
+
+// openDatabase opens the volume state database, failing if another instance
+// holds its lock for longer than the configured timeout
+func openDatabase(config *config) (*bolt.DB, error) {
+	timeout := config.DatabaseTimeout
+	if timeout == 0 {
+		timeout = DefaultDatabaseTimeout
+	}
+
+	if !config.ReadOnly {
+		err := os.MkdirAll(path.Dir(config.DatabasePath), stateMode)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create the state directory: %w", err)
+		}
+	}
+
+	db, err := bolt.Open(config.DatabasePath, 0640, &bolt.Options{
+		Timeout:  timeout,
+		ReadOnly: config.ReadOnly,
 	})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, &common.BackendUnavailable{
+			Backend: "volume database",
+			Err:     fmt.Errorf("%s is locked after waiting %s, is another plugin instance running?", config.DatabasePath, timeout),
+		}
+	}
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to open database %s: %w", config.DatabasePath, err)
 	}
 
-	return &cifsDriver{
-		db:              db,
-		credentialsPath: credentialsPath,
-	}, nil
+	return db, nil
 }
 
-func (driver *cifsDriver) getVolume(name string) (info *volume.Volume, err error) {
+// Close releases the volume state database, waiting for any mount state
+// change in progress
+func (driver *cifsDriver) Close() error {
+	return driver.shutdown(context.Background())
+}
+
+// shutdown releases the volume state database, waiting for any mount state
+// change in progress until the context is done. The database is closed even if
+// a change never finishes, e.g. on a hung mount
+func (driver *cifsDriver) shutdown(ctx context.Context) error {
+	if driver.lock(ctx) {
+		defer driver.mu.Unlock()
+	} else {
+		driver.logger.Warn("closing the database with a mount state change in progress")
+	}
+
+	return driver.db.Close()
+}
+
+// lock acquires the mount state lock, and reports if it did before the
+// context is done. A lock acquired after that is released right away
+func (driver *cifsDriver) lock(ctx context.Context) bool {
+	locked := make(chan struct{})
+	go func() {
+		driver.mu.Lock()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		return true
+	case <-ctx.Done():
+		go func() {
+			<-locked
+			driver.mu.Unlock()
+		}()
+
+		return false
+	}
+}
+
+// mountTable contains the current kernel mounts
+type mountTable interface {
+	IsMounted(target string, fsType string) bool
+}
+
+// reconcile fixes stored volume states that do not match the kernel mount
+// table, e.g. after a host reboot or a plugin crash
+func (driver *cifsDriver) reconcile(mounts mountTable) error {
+	if driver.readOnly {
+		driver.logger.Info("read-only mode, skipping volume state reconciliation")
+		return nil
+	}
+
+	return driver.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(volumeBucket)
+		if bucket == nil {
+			return fmt.Errorf("bucket %s not found", string(volumeBucket))
+		}
+
+		updates := make(map[string]*volumeRecord)
+
+		err := bucket.ForEach(func(k, v []byte) error {
+			record, err := decodeRecord(v)
+			if err != nil {
+				return err
+			}
+
+			mounted := record.Mountpoint != "" && mounts.IsMounted(record.Mountpoint, "cifs")
+			mountpoint := driver.mountpoint(record.Name)
+
+			logger := driver.logger.With("volume", record.Name, "mountpoint", record.Mountpoint)
+
+			switch {
+			case record.Mounted && !mounted:
+				logger.Warn("volume is not mounted, marking as unmounted")
+			case !record.Mounted && mounted:
+				// no mount request references the share, so nothing would release it
+				logger.Warn("volume is mounted without references, unmounting")
+				if driver.unmountOrphan(logger, record.Mountpoint) {
+					mounted = false
+					record.addEvent("reconcile", "", driver.now())
+				}
+			case !record.Mounted && len(record.References) > 0:
+				logger.Warn("clearing stale references")
+			case !mounted && record.Mountpoint != mountpoint:
+				logger.Info("moving mount point", "to", mountpoint)
+			default:
+				return nil
+			}
+
+			if record.Mounted != mounted {
+				record.addEvent("reconcile", "", driver.now())
+			}
+
+			// only the shares mounted through the plugin have requests using them
+			if !mounted || !record.Mounted {
+				record.References = References{}
+			}
+
+			record.Mounted = mounted
+			if !mounted {
+				// mounted volumes keep their current mount point until released
+				record.Mountpoint = mountpoint
+			}
+
+			record.UpdatedAt = driver.now()
+			updates[string(k)] = record
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for name, record := range updates {
+			err = putRecord(bucket, name, record)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// unmountOrphan releases a share mounted without references, and reports if
+// it is no longer mounted. Shares it fails to release are kept as mounted, so
+// removing the volume retries it
+func (driver *cifsDriver) unmountOrphan(logger *common.Logger, mountpoint string) bool {
+	err := driver.mounter.Unmount(mountpoint)
+	if err != nil {
+		logger.Warn("failed to unmount orphaned share", "error", err)
+		return false
+	}
+
+	err = removeMountpoint(mountpoint)
+	if err != nil {
+		logger.Warn("failed to remove mount point", "error", err)
+	}
+
+	return true
+}
+
+func (driver *cifsDriver) getRecord(name string) (*volumeRecord, error) {
+	record, err := driver.findRecord(name)
+	if err == nil && record == nil {
+		err = fmt.Errorf("volume %s does not exist", name)
+	}
+
+	return record, err
+}
+
+// findRecord returns the volume record, or nil if there's none
+func (driver *cifsDriver) findRecord(name string) (record *volumeRecord, err error) {
 	err = driver.db.View(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(volumeBucket)
 		if bucket == nil {
@@ -93,21 +377,18 @@ func (driver *cifsDriver) getVolume(name string) (info *volume.Volume, err error
 
 		value := bucket.Get([]byte(name))
 		if value == nil {
-			return fmt.Errorf("volume %s does not exist", name)
+			return nil
 		}
 
-		return gob.NewDecoder(bytes.NewReader(value)).Decode(&info)
+		record, err = decodeRecord(value)
+		return err
 	})
 
-	return info, err
+	return record, err
 }
 
-func (driver *cifsDriver) putVolume(info *volume.Volume) error {
-	var data bytes.Buffer
-	err := gob.NewEncoder(&data).Encode(info)
-	if err != nil {
-		return err
-	}
+func (driver *cifsDriver) putRecord(record *volumeRecord) error {
+	record.UpdatedAt = driver.now()
 
 	return driver.db.Update(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(volumeBucket)
@@ -115,7 +396,7 @@ func (driver *cifsDriver) putVolume(info *volume.Volume) error {
 			return fmt.Errorf("bucket %s not found", string(volumeBucket))
 		}
 
-		return bucket.Put([]byte(info.Name), data.Bytes())
+		return putRecord(bucket, record.Name, record)
 	})
 }
 
@@ -130,60 +411,162 @@ func (driver *cifsDriver) deleteVolume(name string) error {
 	})
 }
 
-func (driver *cifsDriver) getOptions(status Status) (string, error) {
-	options := status.Options.String()
+// mountpoint returns the volume mount point within the propagated mount root
+func (driver *cifsDriver) mountpoint(name string) string {
+	return path.Join(driver.mountRoot, name)
+}
 
-	segments := strings.Split(strings.TrimPrefix(status.Service, "//"), "/")
-	for index := range segments {
-		fileName := strings.Join(segments[:len(segments)-1-index], `%2F`)
-		info, err := os.Stat(path.Join(driver.credentialsPath, fileName))
-		if errors.Is(err, fs.ErrNotExist) {
-			continue
-		}
+// removeMountpoint removes an unmounted volume directory, if it exists
+func removeMountpoint(mountpoint string) error {
+	err := os.Remove(mountpoint)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// validateName checks if the volume name is usable as a directory name
+func validateName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\x00") {
+		return fmt.Errorf("invalid volume name %q", name)
+	}
+
+	return nil
+}
+
+// getService extracts the UNC path from either the share or service options
+func getService(options Options) (*UNC, error) {
+	share, hasShare := options["share"]
+	service, hasService := options["service"]
+	delete(options, "share")
+	delete(options, "service")
+
+	if hasShare && hasService {
+		shareUNC, err := ParseUNC(share)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
-		if !info.Mode().IsRegular() {
-			continue
+
+		serviceUNC, err := ParseUNC(service)
+		if err != nil {
+			return nil, err
 		}
 
-		if len(options) > 0 {
-			options = fmt.Sprintf("%s,credentials=%s", options, fileName)
-		} else {
-			options = fmt.Sprintf("credentials=%s", fileName)
+		if shareUNC.String() != serviceUNC.String() {
+			return nil, fmt.Errorf("share %s and service %s options disagree", share, service)
 		}
-		break
+
+		return shareUNC, nil
+	}
+
+	if hasService {
+		share = service
 	}
 
-	return options, nil
+	if share == "" {
+		return nil, fmt.Errorf("share must be provided")
+	}
+
+	return ParseUNC(share)
+}
+
+// requestLogger returns the logger with the request fields
+func (driver *cifsDriver) requestLogger(request string, name string, keyvals ...interface{}) *common.Logger {
+	return driver.logger.With(append([]interface{}{"request", request, "volume", name}, keyvals...)...)
+}
+
+// logResult logs the outcome of a request
+func logResult(logger *common.Logger, err error) {
+	if err != nil {
+		logger.Error("request failed", "error", err)
+		return
+	}
+
+	logger.Debug("request handled")
 }
 
-func (driver *cifsDriver) Create(req *volume.CreateRequest) error {
-	service, exists := req.Options["service"]
-	if !exists || service == "" {
-		return fmt.Errorf("service must be provided")
+func (driver *cifsDriver) Create(req *volume.CreateRequest) (err error) {
+	logger := driver.requestLogger("create", req.Name)
+	defer func() { logResult(logger, err) }()
+
+	if driver.readOnly {
+		return ErrReadOnly
+	}
+
+	err = validateName(req.Name)
+	if err != nil {
+		return err
 	}
-	delete(req.Options, "service")
 
-	if !strings.HasPrefix(service, "//") {
-		return fmt.Errorf("service must be a valid UNC path")
+	options := make(Options, len(req.Options))
+	for key, value := range req.Options {
+		options[key] = value
+	}
+
+	unc, err := getService(options)
+	if err != nil {
+		return err
+	}
+
+	credentialsSource, hasCredentialsSource := options[CredentialsSourceOption]
+	delete(options, CredentialsSourceOption)
+
+	if hasCredentialsSource {
+		err = checkCredentialsSource(credentialsSource, driver.allowedOptions)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = validateOptions(options)
+	if err != nil {
+		return err
+	}
+
+	err = checkSensitiveOptions(options, driver.allowedOptions)
+	if err != nil {
+		return err
+	}
+
+	err = checkCredentialsOption(options)
+	if err != nil {
+		return err
 	}
 
-	statusData := make(map[string]interface{})
-	err := mapstructure.Decode(Status{
+	status := Status{
 		Mounted: false,
-		Service: service,
-		Options: req.Options,
-	}, &statusData)
+		Service: unc.String(),
+		Options: mergeOptions(driver.defaultOptions, options),
+		// only the reference is stored, the credentials are read on mount
+		CredentialsSource: credentialsSource,
+		References:        References{},
+	}
+
+	driver.mu.Lock()
+	defer driver.mu.Unlock()
+
+	record, err := driver.findRecord(req.Name)
 	if err != nil {
 		return err
 	}
 
-	return driver.putVolume(&volume.Volume{
+	// creating an existing volume again keeps its mount state and references
+	if record != nil {
+		if record.Service != status.Service ||
+			record.CredentialsSource != status.CredentialsSource ||
+			!reflect.DeepEqual(record.Options, status.Options) {
+			return fmt.Errorf("volume %s already exists with different options", req.Name)
+		}
+
+		return nil
+	}
+
+	return driver.putRecord(&volumeRecord{
 		Name:       req.Name,
-		Mountpoint: "",
-		CreatedAt:  time.Now().String(),
-		Status:     statusData,
+		Mountpoint: driver.mountpoint(req.Name),
+		Status:     status,
+		CreatedAt:  driver.now(),
 	})
 }
 
@@ -199,14 +582,17 @@ func (driver *cifsDriver) List() (response *volume.ListResponse, err error) {
 		response.Volumes = make([]*volume.Volume, 0, bucket.Stats().KeyN)
 
 		return bucket.ForEach(func(k, v []byte) error {
-			var info volume.Volume
+			record, err := decodeRecord(v)
+			if err != nil {
+				return err
+			}
 
-			err = gob.NewDecoder(bytes.NewReader(v)).Decode(&info)
+			info, err := record.Volume()
 			if err != nil {
 				return err
 			}
 
-			response.Volumes = append(response.Volumes, &info)
+			response.Volumes = append(response.Volumes, info)
 
 			return nil
 		})
@@ -216,114 +602,253 @@ func (driver *cifsDriver) List() (response *volume.ListResponse, err error) {
 }
 
 func (driver *cifsDriver) Get(req *volume.GetRequest) (*volume.GetResponse, error) {
-	info, err := driver.getVolume(req.Name)
+	record, err := driver.getRecord(req.Name)
+	if err != nil {
+		return &volume.GetResponse{}, err
+	}
+
+	info, err := record.Volume()
 
 	return &volume.GetResponse{
 		Volume: info,
 	}, err
 }
 
-func (driver *cifsDriver) Remove(req *volume.RemoveRequest) error {
+func (driver *cifsDriver) Remove(req *volume.RemoveRequest) (err error) {
+	logger := driver.requestLogger("remove", req.Name)
+	defer func() { logResult(logger, err) }()
+
+	if driver.readOnly {
+		return ErrReadOnly
+	}
+
+	driver.mu.Lock()
+	defer driver.mu.Unlock()
+
+	record, err := driver.getRecord(req.Name)
+	if err != nil {
+		return err
+	}
+
+	if len(record.References) > 0 {
+		return fmt.Errorf("volume %s is in use by %d mount(s)", req.Name, len(record.References))
+	}
+
+	// shares mounted without references, as left by a failed reconciliation,
+	// must be released before their record is gone
+	if record.Mounted {
+		err = driver.mounter.Unmount(record.Mountpoint)
+		if err != nil {
+			return err
+		}
+
+		logger.Info("share unmounted", "service", record.Service)
+	}
+
+	err = removeMountpoint(record.Mountpoint)
+	if err != nil {
+		return err
+	}
+
 	return driver.deleteVolume(req.Name)
 }
 
 func (driver *cifsDriver) Path(req *volume.PathRequest) (*volume.PathResponse, error) {
-	info, err := driver.getVolume(req.Name)
+	record, err := driver.getRecord(req.Name)
 	if err != nil {
 		return nil, err
 	}
 
 	return &volume.PathResponse{
-		Mountpoint: info.Mountpoint,
+		Mountpoint: record.Mountpoint,
 	}, nil
 }
 
-func (driver *cifsDriver) Mount(req *volume.MountRequest) (*volume.MountResponse, error) {
-	info, err := driver.getVolume(req.Name)
-	if err != nil {
-		return nil, err
-	}
-	if info == nil {
-		return nil, fmt.Errorf("volume %s not found", req.Name)
+func (driver *cifsDriver) Mount(req *volume.MountRequest) (response *volume.MountResponse, err error) {
+	logger := driver.requestLogger("mount", req.Name, "id", req.ID)
+	defer func() { logResult(logger, err) }()
+
+	if driver.readOnly {
+		return nil, ErrReadOnly
 	}
 
-	var status Status
-	err = mapstructure.Decode(info.Status, &status)
+	driver.mu.Lock()
+	defer driver.mu.Unlock()
+
+	record, err := driver.getRecord(req.Name)
 	if err != nil {
 		return nil, err
 	}
 
-	if status.Mounted {
-		return nil, fmt.Errorf("volume %s is already mounted", req.Name)
+	if record.References == nil {
+		record.References = References{}
 	}
 
-	options, err := driver.getOptions(status)
-	if err != nil {
-		return nil, err
-	}
+	if !record.Mounted {
+		// guards against records stored before options were validated
+		err = validateOptions(record.Options)
+		if err != nil {
+			return nil, err
+		}
 
-	info.Mountpoint = path.Join(volume.DefaultDockerRootDirectory, req.ID)
+		err = checkSensitiveOptions(volumeOptions(driver.defaultOptions, record.Options), driver.allowedOptions)
+		if err != nil {
+			return nil, err
+		}
 
-	cmd := exec.Command("mount", "-t", "cifs", "-o", options, status.Service, info.Mountpoint)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+		if record.CredentialsSource != "" {
+			err = checkCredentialsSource(record.CredentialsSource, driver.allowedOptions)
+			if err != nil {
+				return nil, err
+			}
+		}
 
-	err = cmd.Run()
-	if err != nil {
-		return nil, err
-	}
+		credentials, err := driver.getCredentials(record.Status)
+		if err != nil {
+			return nil, err
+		}
 
-	status.Mounted = true
+		record.Mountpoint = driver.mountpoint(req.Name)
 
-	err = mapstructure.Decode(status, &info.Status)
-	if err != nil {
-		return nil, err
+		err = os.MkdirAll(record.Mountpoint, mountpointMode)
+		if err != nil {
+			return nil, err
+		}
+
+		err = driver.mounter.Mount(record.Service, record.Mountpoint, mountOptions(record.Options), credentials)
+		if err != nil {
+			removeMountpoint(record.Mountpoint) //nolint:errcheck
+			return nil, err
+		}
+
+		record.Mounted = true
+		record.addEvent("mount", req.ID, driver.now())
+		logger.Info("share mounted", "service", record.Service, "mountpoint", record.Mountpoint)
 	}
 
-	err = driver.putVolume(info)
+	record.References[req.ID] = true
+
+	err = driver.putRecord(record)
 
 	return &volume.MountResponse{
-		Mountpoint: info.Mountpoint,
+		Mountpoint: record.Mountpoint,
 	}, err
 }
 
-func (driver *cifsDriver) Unmount(req *volume.UnmountRequest) error {
-	info, err := driver.getVolume(req.Name)
+func (driver *cifsDriver) Unmount(req *volume.UnmountRequest) (err error) {
+	logger := driver.requestLogger("unmount", req.Name, "id", req.ID)
+	defer func() { logResult(logger, err) }()
+
+	if driver.readOnly {
+		return ErrReadOnly
+	}
+
+	driver.mu.Lock()
+	defer driver.mu.Unlock()
+
+	record, err := driver.getRecord(req.Name)
 	if err != nil {
 		return err
 	}
 
-	if info == nil {
-		return fmt.Errorf("volume %s not found", req.Name)
+	if !record.Mounted {
+		return fmt.Errorf("volume %s is not mounted", req.Name)
 	}
 
-	mountPoint := path.Join(volume.DefaultDockerRootDirectory, req.ID)
-	if info.Mountpoint != mountPoint {
-		return fmt.Errorf("volume %s mount point does not match", req.Name)
+	if !record.References[req.ID] {
+		return fmt.Errorf("volume %s is not mounted by %s", req.Name, req.ID)
 	}
 
-	status := Status{}
-	err = mapstructure.Decode(info.Status, &status)
-	if err != nil {
-		return err
+	delete(record.References, req.ID)
+
+	// only the last user actually unmounts the share
+	if len(record.References) == 0 {
+		err = driver.mounter.Unmount(record.Mountpoint)
+		if err != nil {
+			return err
+		}
+
+		// the share is already released, so the record must reflect it even if
+		// the directory stays behind
+		err = removeMountpoint(record.Mountpoint)
+		if err != nil {
+			logger.Warn("failed to remove mount point", "mountpoint", record.Mountpoint, "error", err)
+		}
+
+		record.Mounted = false
+		record.Mountpoint = driver.mountpoint(req.Name)
+		record.addEvent("unmount", req.ID, driver.now())
+		logger.Info("share unmounted", "service", record.Service)
 	}
 
-	if !status.Mounted {
-		return fmt.Errorf("volume %s is not mounted", req.Name)
+	return driver.putRecord(record)
+}
+
+// unmountAll releases every mounted volume, regardless of its references.
+// It keeps going on failures, and returns the first one. It gives up if a mount
+// state change is still in progress when the context is done
+func (driver *cifsDriver) unmountAll(ctx context.Context) error {
+	if driver.readOnly {
+		return nil
+	}
+
+	if !driver.lock(ctx) {
+		return fmt.Errorf("mount state change in progress: %w", ctx.Err())
 	}
+	defer driver.mu.Unlock()
 
-	cmd := exec.Command("umount", info.Mountpoint)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	records := make([]*volumeRecord, 0)
+	err := driver.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(volumeBucket)
+		if bucket == nil {
+			return fmt.Errorf("bucket %s not found", string(volumeBucket))
+		}
 
-	err = cmd.Run()
+		return bucket.ForEach(func(k, v []byte) error {
+			record, err := decodeRecord(v)
+			if err != nil {
+				return err
+			}
+
+			if record.Mounted {
+				records = append(records, record)
+			}
+
+			return nil
+		})
+	})
 	if err != nil {
 		return err
 	}
 
-	info.Mountpoint = ""
+	var firstErr error
+	for _, record := range records {
+		err = driver.mounter.Unmount(record.Mountpoint)
+		if err != nil {
+			driver.logger.Error("failed to unmount volume", "volume", record.Name, "error", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		err = removeMountpoint(record.Mountpoint)
+		if err != nil {
+			driver.logger.Warn("failed to remove mount point", "volume", record.Name, "mountpoint", record.Mountpoint, "error", err)
+		}
+
+		record.Mounted = false
+		record.Mountpoint = driver.mountpoint(record.Name)
+		record.References = References{}
+		record.addEvent("shutdown", "", driver.now())
+
+		err = driver.putRecord(record)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
 
-	return driver.putVolume(info)
+	return firstErr
 }
 
 func (driver *cifsDriver) Capabilities() *volume.CapabilitiesResponse {
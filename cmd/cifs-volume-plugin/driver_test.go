@@ -0,0 +1,885 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/wwmoraes/docker-engine-plugins/internal/common"
+	bolt "go.etcd.io/bbolt"
+)
+
+var mockNow = time.Date(2022, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+type mounterCall struct {
+	Op          string
+	Source      string
+	Target      string
+	Options     Options
+	Credentials *Credentials
+}
+
+// fakeMounter records the mount calls, and fails them if err is set
+type fakeMounter struct {
+	mu    sync.Mutex
+	calls []mounterCall
+	err   error
+}
+
+func (mounter *fakeMounter) Mount(source string, target string, options Options, credentials *Credentials) error {
+	mounter.mu.Lock()
+	defer mounter.mu.Unlock()
+
+	mounter.calls = append(mounter.calls, mounterCall{"mount", source, target, options, credentials})
+	return mounter.err
+}
+
+func (mounter *fakeMounter) Unmount(target string) error {
+	mounter.mu.Lock()
+	defer mounter.mu.Unlock()
+
+	mounter.calls = append(mounter.calls, mounterCall{"umount", "", target, nil, nil})
+	return mounter.err
+}
+
+// fakeMountTable contains the mounted targets
+type fakeMountTable map[string]string
+
+func (mounts fakeMountTable) IsMounted(target string, fsType string) bool {
+	return mounts[target] == fsType
+}
+
+func newTestDriver(tb testing.TB, dir string, mounter Mounter, mounts mountTable) *cifsDriver {
+	tb.Helper()
+
+	credentialsPath := path.Join(dir, "credentials")
+	err := os.MkdirAll(credentialsPath, 0700)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	driver, err := NewDriver(&config{
+		CredentialsPath: credentialsPath,
+		DatabasePath:    path.Join(dir, "cifs.db"),
+		MountRoot:       path.Join(dir, "volumes"),
+	}, mounter, mounts, common.DiscardLogger())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { driver.Close() })
+
+	driver.now = func() time.Time { return mockNow }
+
+	return driver
+}
+
+func getStatus(tb testing.TB, driver *cifsDriver, name string) (*volumeRecord, Status) {
+	tb.Helper()
+
+	record, err := driver.getRecord(name)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return record, record.Status
+}
+
+func TestNewDriver(t *testing.T) {
+	_, err := NewDriver(&config{
+		DatabasePath: path.Join(t.TempDir(), "cifs.db"),
+	}, nil, fakeMountTable{}, common.DiscardLogger())
+	if err == nil {
+		t.Errorf("NewDriver() error = nil, want error without a mounter")
+	}
+
+	_, err = NewDriver(&config{
+		DatabasePath: path.Join(t.TempDir(), "cifs.db"),
+	}, &fakeMounter{}, fakeMountTable{}, nil)
+	if err == nil {
+		t.Errorf("NewDriver() error = nil, want error without a logger")
+	}
+
+	dir := t.TempDir()
+	err = os.WriteFile(path.Join(dir, "state"), nil, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewDriver(&config{
+		DatabasePath: path.Join(dir, "state", "cifs.db"),
+	}, &fakeMounter{}, fakeMountTable{}, common.DiscardLogger())
+	if err == nil {
+		t.Errorf("NewDriver() error = nil, want error on an invalid database path")
+	}
+
+	driver, err := NewDriver(&config{
+		DatabasePath: path.Join(dir, "missing", "cifs.db"),
+	}, &fakeMounter{}, fakeMountTable{}, common.DiscardLogger())
+	if err != nil {
+		t.Fatalf("NewDriver() error = %v, want the state directory created", err)
+	}
+	driver.Close()
+}
+
+func TestNewDriver_locked(t *testing.T) {
+	dir := t.TempDir()
+	newTestDriver(t, dir, &fakeMounter{}, fakeMountTable{})
+
+	_, err := NewDriver(&config{
+		DatabasePath:    path.Join(dir, "cifs.db"),
+		DatabaseTimeout: 50 * time.Millisecond,
+	}, &fakeMounter{}, fakeMountTable{}, common.DiscardLogger())
+	if got := common.ExitCode(err); got != common.ExitBackendUnavailable {
+		t.Errorf("NewDriver() error = %v, exit code %d, want %d on a locked database", err, got, common.ExitBackendUnavailable)
+	}
+}
+
+func TestCifsDriver_readOnly(t *testing.T) {
+	dir := t.TempDir()
+	driver := newTestDriver(t, dir, &fakeMounter{}, fakeMountTable{})
+
+	err := driver.Create(&volume.CreateRequest{
+		Name:    "foo",
+		Options: map[string]string{"service": "//host/foo"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	driver.Close()
+
+	mounter := &fakeMounter{}
+	driver, err = NewDriver(&config{
+		DatabasePath: path.Join(dir, "cifs.db"),
+		ReadOnly:     true,
+	}, mounter, fakeMountTable{}, common.DiscardLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Close()
+
+	list, err := driver.List()
+	if err != nil || len(list.Volumes) != 1 {
+		t.Errorf("cifsDriver.List() = %v, %v, want foo", list.Volumes, err)
+	}
+
+	err = driver.Create(&volume.CreateRequest{
+		Name:    "bar",
+		Options: map[string]string{"service": "//host/bar"},
+	})
+	if !errors.Is(err, ErrReadOnly) {
+		t.Errorf("cifsDriver.Create() error = %v, want %v", err, ErrReadOnly)
+	}
+
+	_, err = driver.Mount(&volume.MountRequest{Name: "foo", ID: "a"})
+	if !errors.Is(err, ErrReadOnly) {
+		t.Errorf("cifsDriver.Mount() error = %v, want %v", err, ErrReadOnly)
+	}
+
+	if len(mounter.calls) != 0 {
+		t.Errorf("mounter calls = %#+v, want none on read-only mode", mounter.calls)
+	}
+}
+
+func TestCifsDriver_lifecycle(t *testing.T) {
+	mounter := &fakeMounter{}
+	driver := newTestDriver(t, t.TempDir(), mounter, fakeMountTable{})
+
+	err := driver.Create(&volume.CreateRequest{
+		Name:    "foo",
+		Options: map[string]string{"service": "//host/foo", "vers": "3.0"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := driver.Get(&volume.GetRequest{Name: "foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Volume.CreatedAt != mockNow.Format(time.RFC3339) {
+		t.Errorf("cifsDriver.Get() CreatedAt = %s, want %s", got.Volume.CreatedAt, mockNow.Format(time.RFC3339))
+	}
+
+	wantMountpoint := path.Join(driver.mountRoot, "foo")
+	if got.Volume.Mountpoint != wantMountpoint {
+		t.Errorf("cifsDriver.Get() Mountpoint = %s, want %s", got.Volume.Mountpoint, wantMountpoint)
+	}
+
+	list, err := driver.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Volumes) != 1 || list.Volumes[0].Name != "foo" {
+		t.Errorf("cifsDriver.List() = %v, want only foo", list.Volumes)
+	}
+
+	first, err := driver.Mount(&volume.MountRequest{Name: "foo", ID: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := driver.Mount(&volume.MountRequest{Name: "foo", ID: "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.Mountpoint != wantMountpoint || second.Mountpoint != wantMountpoint {
+		t.Errorf("cifsDriver.Mount() mount points = %s and %s, want %s", first.Mountpoint, second.Mountpoint, wantMountpoint)
+	}
+
+	if info, err := os.Stat(wantMountpoint); err != nil || !info.IsDir() {
+		t.Errorf("cifsDriver.Mount() did not create the mount point directory: %v", err)
+	}
+
+	pathResponse, err := driver.Path(&volume.PathRequest{Name: "foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pathResponse.Mountpoint != first.Mountpoint {
+		t.Errorf("cifsDriver.Path() = %s, want %s", pathResponse.Mountpoint, first.Mountpoint)
+	}
+
+	_, status := getStatus(t, driver, "foo")
+	if !status.Mounted || !reflect.DeepEqual(status.References, References{"a": true, "b": true}) {
+		t.Errorf("cifsDriver.Mount() status = %#+v, want mounted by a and b", status)
+	}
+
+	err = driver.Remove(&volume.RemoveRequest{Name: "foo"})
+	if err == nil {
+		t.Errorf("cifsDriver.Remove() error = nil, want error on a volume in use")
+	}
+
+	err = driver.Unmount(&volume.UnmountRequest{Name: "foo", ID: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = driver.Unmount(&volume.UnmountRequest{Name: "foo", ID: "a"})
+	if err == nil {
+		t.Errorf("cifsDriver.Unmount() error = nil, want error on a released reference")
+	}
+
+	err = driver.Unmount(&volume.UnmountRequest{Name: "foo", ID: "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, status := getStatus(t, driver, "foo")
+	if status.Mounted || len(status.References) != 0 || info.Mountpoint != wantMountpoint {
+		t.Errorf("cifsDriver.Unmount() volume = %#+v, want unmounted", info)
+	}
+
+	if _, err := os.Stat(wantMountpoint); !os.IsNotExist(err) {
+		t.Errorf("cifsDriver.Unmount() mount point stat error = %v, want not exist", err)
+	}
+
+	wantHistory := []mountEvent{
+		{Op: "mount", ID: "a", Time: mockNow},
+		{Op: "unmount", ID: "b", Time: mockNow},
+	}
+	if !reflect.DeepEqual(info.History, wantHistory) {
+		t.Errorf("cifsDriver.Unmount() history = %#+v, want %#+v", info.History, wantHistory)
+	}
+
+	err = driver.Remove(&volume.RemoveRequest{Name: "foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = driver.Get(&volume.GetRequest{Name: "foo"})
+	if err == nil {
+		t.Errorf("cifsDriver.Get() error = nil, want error on a removed volume")
+	}
+
+	wantCalls := []mounterCall{
+		{"mount", "//host/foo", first.Mountpoint, Options{"vers": "3.0"}, nil},
+		{"umount", "", first.Mountpoint, nil, nil},
+	}
+	if !reflect.DeepEqual(mounter.calls, wantCalls) {
+		t.Errorf("mounter calls = %#+v, want %#+v", mounter.calls, wantCalls)
+	}
+}
+
+func TestCifsDriver_Mount_failure(t *testing.T) {
+	mounter := &fakeMounter{err: &MountError{Op: "mount", Err: os.ErrPermission}}
+	driver := newTestDriver(t, t.TempDir(), mounter, fakeMountTable{})
+
+	err := driver.Create(&volume.CreateRequest{
+		Name:    "foo",
+		Options: map[string]string{"service": "//host/foo"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = driver.Mount(&volume.MountRequest{Name: "foo", ID: "a"})
+	if err == nil {
+		t.Fatal("cifsDriver.Mount() error = nil, want error")
+	}
+
+	info, status := getStatus(t, driver, "foo")
+	if status.Mounted || len(status.References) != 0 {
+		t.Errorf("cifsDriver.Mount() status = %#+v, want unmounted after failure", status)
+	}
+
+	if _, err := os.Stat(info.Mountpoint); !os.IsNotExist(err) {
+		t.Errorf("cifsDriver.Mount() mount point stat error = %v, want not exist after failure", err)
+	}
+}
+
+func TestCifsDriver_Unmount_mountpointLeft(t *testing.T) {
+	driver := newTestDriver(t, t.TempDir(), &fakeMounter{}, fakeMountTable{})
+
+	err := driver.Create(&volume.CreateRequest{
+		Name:    "foo",
+		Options: map[string]string{"service": "//host/foo"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := driver.Mount(&volume.MountRequest{Name: "foo", ID: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a non-empty directory cannot be removed
+	err = os.WriteFile(path.Join(response.Mountpoint, "leftover"), nil, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = driver.Unmount(&volume.UnmountRequest{Name: "foo", ID: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, status := getStatus(t, driver, "foo")
+	if status.Mounted || len(status.References) != 0 {
+		t.Errorf("cifsDriver.Unmount() status = %#+v, want unmounted", status)
+	}
+}
+
+func TestCifsDriver_Mount_unsafeOptions(t *testing.T) {
+	mounter := &fakeMounter{}
+	driver := newTestDriver(t, t.TempDir(), mounter, fakeMountTable{})
+
+	err := driver.Create(&volume.CreateRequest{
+		Name:    "foo",
+		Options: map[string]string{"service": "//host/foo"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// records stored before the option was rejected
+	record, _ := getStatus(t, driver, "foo")
+	record.Options["addr"] = "10.0.0.66"
+
+	err = driver.putRecord(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = driver.Mount(&volume.MountRequest{Name: "foo", ID: "a"})
+	if err == nil {
+		t.Fatal("cifsDriver.Mount() error = nil, want error")
+	}
+
+	if len(mounter.calls) != 0 {
+		t.Errorf("cifsDriver.Mount() mounter calls = %v, want none", mounter.calls)
+	}
+}
+
+func TestCifsDriver_Create(t *testing.T) {
+	driver := newTestDriver(t, t.TempDir(), &fakeMounter{}, fakeMountTable{})
+
+	tests := []struct {
+		name    string
+		req     *volume.CreateRequest
+		wantErr bool
+	}{
+		{
+			name: "valid service",
+			req: &volume.CreateRequest{
+				Name:    "foo",
+				Options: map[string]string{"service": "//host/foo"},
+			},
+		},
+		{
+			name: "valid share",
+			req: &volume.CreateRequest{
+				Name:    "bar",
+				Options: map[string]string{"share": "//host/bar"},
+			},
+		},
+		{
+			name: "matching share and service",
+			req: &volume.CreateRequest{
+				Name:    "baz",
+				Options: map[string]string{"share": "//host/baz", "service": "//host/baz/"},
+			},
+		},
+		{
+			name: "disagreeing share and service",
+			req: &volume.CreateRequest{
+				Name:    "qux",
+				Options: map[string]string{"share": "//host/qux", "service": "//host/baz"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing service",
+			req: &volume.CreateRequest{
+				Name:    "qux",
+				Options: map[string]string{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "injected option",
+			req: &volume.CreateRequest{
+				Name:    "qux",
+				Options: map[string]string{"share": "//host/qux", "vers": "3.0,credentials=/etc/shadow"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "credentials option",
+			req: &volume.CreateRequest{
+				Name:    "qux",
+				Options: map[string]string{"share": "//host/qux", "credentials": "/etc/shadow"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "server address option",
+			req: &volume.CreateRequest{
+				Name:    "qux",
+				Options: map[string]string{"share": "//host/qux", "ip": "10.0.0.66"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown option",
+			req: &volume.CreateRequest{
+				Name:    "qux",
+				Options: map[string]string{"share": "//host/qux", "vesr": "3.0"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "path traversal name",
+			req: &volume.CreateRequest{
+				Name:    "../qux",
+				Options: map[string]string{"share": "//host/qux"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid service",
+			req: &volume.CreateRequest{
+				Name:    "bar",
+				Options: map[string]string{"service": "host/foo"},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := driver.Create(tt.req); (err != nil) != tt.wantErr {
+				t.Errorf("cifsDriver.Create() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCifsDriver_Create_existing(t *testing.T) {
+	driver := newTestDriver(t, t.TempDir(), &fakeMounter{}, fakeMountTable{})
+
+	req := &volume.CreateRequest{
+		Name:    "foo",
+		Options: map[string]string{"service": "//host/foo", "vers": "3.0"},
+	}
+
+	err := driver.Create(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = driver.Mount(&volume.MountRequest{Name: "foo", ID: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = driver.Create(req)
+	if err != nil {
+		t.Errorf("cifsDriver.Create() error = %v, want nil on the same options", err)
+	}
+
+	err = driver.Create(&volume.CreateRequest{
+		Name:    "foo",
+		Options: map[string]string{"service": "//other/foo"},
+	})
+	if err == nil {
+		t.Errorf("cifsDriver.Create() error = nil, want error on different options")
+	}
+
+	_, status := getStatus(t, driver, "foo")
+	want := Status{
+		Mounted:    true,
+		Service:    "//host/foo",
+		Options:    Options{"vers": "3.0"},
+		References: References{"a": true},
+	}
+	if !reflect.DeepEqual(status, want) {
+		t.Errorf("cifsDriver.Create() status = %#+v, want %#+v", status, want)
+	}
+}
+
+func TestCifsDriver_reconcile(t *testing.T) {
+	dir := t.TempDir()
+	driver := newTestDriver(t, dir, &fakeMounter{}, fakeMountTable{})
+
+	for _, name := range []string{"stale", "alive"} {
+		err := driver.Create(&volume.CreateRequest{
+			Name:    name,
+			Options: map[string]string{"service": "//host/" + name},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = driver.Mount(&volume.MountRequest{Name: name, ID: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	alive, _ := getStatus(t, driver, "alive")
+
+	// records from older versions have container-based mount points
+	legacy, _ := getStatus(t, driver, "stale")
+	legacy.Mountpoint = path.Join(volume.DefaultDockerRootDirectory, "stale")
+
+	err := driver.putRecord(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = driver.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	driver = newTestDriver(t, dir, &fakeMounter{}, fakeMountTable{
+		alive.Mountpoint: "cifs",
+	})
+
+	info, status := getStatus(t, driver, "stale")
+	if status.Mounted || len(status.References) != 0 || info.Mountpoint != path.Join(driver.mountRoot, "stale") {
+		t.Errorf("cifsDriver.reconcile() stale volume = %#+v, want unmounted", info)
+	}
+
+	info, status = getStatus(t, driver, "alive")
+	if !status.Mounted || !status.References["alive"] || info.Mountpoint != alive.Mountpoint {
+		t.Errorf("cifsDriver.reconcile() alive volume = %#+v, want mounted", info)
+	}
+}
+
+func TestCifsDriver_reconcile_orphan(t *testing.T) {
+	dir := t.TempDir()
+	driver := newTestDriver(t, dir, &fakeMounter{}, fakeMountTable{})
+
+	for _, name := range []string{"released", "kept"} {
+		err := driver.Create(&volume.CreateRequest{
+			Name:    name,
+			Options: map[string]string{"service": "//host/" + name},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	released, _ := getStatus(t, driver, "released")
+	kept, _ := getStatus(t, driver, "kept")
+
+	err := driver.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// shares mounted while the records were stored as unmounted
+	mounts := fakeMountTable{
+		released.Mountpoint: "cifs",
+		kept.Mountpoint:     "cifs",
+	}
+
+	mounter := &fakeMounter{}
+	driver = newTestDriver(t, dir, mounter, mounts)
+
+	if len(mounter.calls) != 2 {
+		t.Fatalf("cifsDriver.reconcile() mounter calls = %v, want both shares unmounted", mounter.calls)
+	}
+
+	_, status := getStatus(t, driver, "released")
+	if status.Mounted {
+		t.Errorf("cifsDriver.reconcile() released volume status = %#+v, want unmounted", status)
+	}
+
+	err = driver.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mounter = &fakeMounter{err: &MountError{Op: "umount", Err: os.ErrPermission}}
+	driver = newTestDriver(t, dir, mounter, fakeMountTable{kept.Mountpoint: "cifs"})
+
+	_, status = getStatus(t, driver, "kept")
+	if !status.Mounted || len(status.References) != 0 {
+		t.Fatalf("cifsDriver.reconcile() kept volume status = %#+v, want mounted without references", status)
+	}
+
+	err = driver.Remove(&volume.RemoveRequest{Name: "kept"})
+	if err == nil {
+		t.Fatal("cifsDriver.Remove() error = nil, want the unmount error")
+	}
+
+	mounter.err = nil
+
+	err = driver.Remove(&volume.RemoveRequest{Name: "kept"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := mounterCall{"umount", "", kept.Mountpoint, nil, nil}
+	if got := mounter.calls[len(mounter.calls)-1]; !reflect.DeepEqual(got, want) {
+		t.Errorf("cifsDriver.Remove() mounter call = %v, want %v", got, want)
+	}
+
+	_, err = driver.getRecord("kept")
+	if err == nil {
+		t.Errorf("cifsDriver.Remove() kept the record of a mounted share")
+	}
+}
+
+func Test_mergeOptions(t *testing.T) {
+	defaults := Options{"vers": "3.0", "uid": "1000", "ro": ""}
+
+	tests := []struct {
+		name    string
+		options Options
+		want    Options
+	}{
+		{
+			name:    "defaults only",
+			options: Options{},
+			want:    Options{"vers": "3.0", "uid": "1000", "ro": ""},
+		},
+		{
+			name:    "volume value wins",
+			options: Options{"vers": "2.1", "gid": "1000"},
+			want:    Options{"vers": "2.1", "uid": "1000", "ro": "", "gid": "1000"},
+		},
+		{
+			name:    "negated defaults",
+			options: Options{"!ro": "", "!uid": "", "!missing": ""},
+			want:    Options{"vers": "3.0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeOptions(defaults, tt.options); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewDriver_invalidDefaultOptions(t *testing.T) {
+	tests := []struct {
+		name           string
+		defaultOptions string
+	}{
+		{"unknown option", "vesr=3.0"},
+		{"server address", "ip=10.0.0.1"},
+		{"kerberos user", "cruid=0"},
+		{"password", "password=secret"},
+		{"short password", "pass=secret"},
+		{"absolute credentials file", "credentials=/etc/x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewDriver(&config{
+				DatabasePath:   path.Join(t.TempDir(), "cifs.db"),
+				DefaultOptions: tt.defaultOptions,
+			}, &fakeMounter{}, fakeMountTable{}, common.DiscardLogger())
+			if err == nil {
+				t.Fatalf("NewDriver() error = nil, want error on %s", tt.defaultOptions)
+			}
+			if got := common.ExitCode(err); got != common.ExitConfigError {
+				t.Errorf("NewDriver() exit code = %d, want %d on %s", got, common.ExitConfigError, tt.defaultOptions)
+			}
+		})
+	}
+}
+
+func TestCifsDriver_Mount_sensitiveDefaultOptions(t *testing.T) {
+	dir := t.TempDir()
+	credentialsPath := path.Join(dir, "credentials")
+	err := os.MkdirAll(credentialsPath, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mounter := &fakeMounter{}
+	driver, err := NewDriver(&config{
+		CredentialsPath: credentialsPath,
+		DatabasePath:    path.Join(dir, "cifs.db"),
+		MountRoot:       path.Join(dir, "volumes"),
+		DefaultOptions:  "domain=CORP",
+	}, mounter, fakeMountTable{}, common.DiscardLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Close()
+
+	err = driver.Create(&volume.CreateRequest{
+		Name:    "foo",
+		Options: map[string]string{"service": "//host/foo"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = driver.Mount(&volume.MountRequest{Name: "foo", ID: "a"})
+	if err != nil {
+		t.Fatalf("cifsDriver.Mount() error = %v, want the default domain allowed", err)
+	}
+
+	if len(mounter.calls) != 1 || mounter.calls[0].Credentials.Domain != "CORP" {
+		t.Errorf("cifsDriver.Mount() mounter calls = %v, want the default domain", mounter.calls)
+	}
+
+	// a volume still can't change the default to a value of its own
+	record, _ := getStatus(t, driver, "foo")
+	record.Mounted = false
+	record.References = References{}
+	record.Options["domain"] = "OTHER"
+
+	err = driver.putRecord(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = driver.Mount(&volume.MountRequest{Name: "foo", ID: "b"})
+	if err == nil {
+		t.Errorf("cifsDriver.Mount() error = nil, want error on a domain set by the volume")
+	}
+}
+
+func TestCifsDriver_Create_defaultOptions(t *testing.T) {
+	dir := t.TempDir()
+	credentialsPath := path.Join(dir, "credentials")
+	err := os.MkdirAll(credentialsPath, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	driver, err := NewDriver(&config{
+		CredentialsPath: credentialsPath,
+		DatabasePath:    path.Join(dir, "cifs.db"),
+		DefaultOptions:  "vers=3.0,uid=1000,noperm",
+	}, &fakeMounter{}, fakeMountTable{}, common.DiscardLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Close()
+
+	err = driver.Create(&volume.CreateRequest{
+		Name:    "foo",
+		Options: map[string]string{"service": "//host/foo", "uid": "0", "!noperm": ""},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, status := getStatus(t, driver, "foo")
+
+	want := Options{"vers": "3.0", "uid": "0"}
+	if !reflect.DeepEqual(status.Options, want) {
+		t.Errorf("cifsDriver.Create() options = %v, want %v", status.Options, want)
+	}
+}
+
+func TestCifsDriver_unmountAll(t *testing.T) {
+	mounter := &fakeMounter{}
+	driver := newTestDriver(t, t.TempDir(), mounter, fakeMountTable{})
+
+	for _, name := range []string{"foo", "bar"} {
+		err := driver.Create(&volume.CreateRequest{
+			Name:    name,
+			Options: map[string]string{"service": "//host/" + name},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := driver.Mount(&volume.MountRequest{Name: "foo", ID: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = driver.unmountAll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, status := getStatus(t, driver, "foo")
+	if status.Mounted || len(status.References) != 0 {
+		t.Errorf("cifsDriver.unmountAll() volume = %#+v, want unmounted", info)
+	}
+
+	wantCalls := []mounterCall{
+		{"mount", "//host/foo", info.Mountpoint, Options{}, nil},
+		{"umount", "", info.Mountpoint, nil, nil},
+	}
+	if !reflect.DeepEqual(mounter.calls, wantCalls) {
+		t.Errorf("mounter calls = %#+v, want %#+v", mounter.calls, wantCalls)
+	}
+}
+
+func TestCifsDriver_shutdown_stuck(t *testing.T) {
+	driver := newTestDriver(t, t.TempDir(), &fakeMounter{}, fakeMountTable{})
+
+	// a request stuck on a hung mount holds the lock
+	driver.mu.Lock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err := driver.unmountAll(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("cifsDriver.unmountAll() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	err = driver.shutdown(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = driver.getRecord("foo")
+	if !errors.Is(err, bolt.ErrDatabaseNotOpen) {
+		t.Errorf("cifsDriver.shutdown() database error = %v, want %v", err, bolt.ErrDatabaseNotOpen)
+	}
+
+	driver.mu.Unlock()
+}
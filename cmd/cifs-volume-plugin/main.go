@@ -1,22 +1,118 @@
 package main
 
 import (
-	"fmt"
-	"log"
+	"errors"
+	"flag"
+	"io/fs"
 	"os"
+	"path"
 
 	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/wwmoraes/docker-engine-plugins/internal/common"
+	"github.com/wwmoraes/docker-engine-plugins/internal/mount"
+)
+
+var (
+	inspect = flag.Bool("inspect", false, "print the stored volume records using a read-only database and exit")
+	check   = flag.Bool("check", false, "validate the configuration, mount table and volume database, then exit")
 )
 
 func main() {
-	driver, err := NewDriver()
+	flag.Parse()
+
+	logger, err := common.NewLoggerFromEnv("cifs")
+	if err != nil {
+		os.Exit(common.StartupFailed(os.Stderr, &common.ConfigError{Err: err}))
+	}
+
+	config, err := newConfig()
+	if err != nil {
+		os.Exit(common.StartupFailed(os.Stderr, &common.ConfigError{Err: err}))
+	}
+
+	if *check {
+		err = checkDriver(config, logger)
+		if err != nil {
+			os.Exit(common.StartupFailed(os.Stderr, err))
+		}
+
+		logger.Info("configuration, mount table and volume database are valid")
+		return
+	}
+
+	if *inspect {
+		config.ReadOnly = true
+	}
+
+	driver, err := newDriverFromConfig(config, logger)
+	if err != nil {
+		os.Exit(common.StartupFailed(os.Stderr, err))
+	}
+
+	if *inspect {
+		err = driver.inspect(os.Stdout)
+		driver.Close()
+		if err != nil {
+			logger.Error("failed to inspect the volume records", "error", err)
+			os.Exit(common.ExitStartupError)
+		}
+
+		return
+	}
+
+	lifecycle := common.NewLifecycle("smbfs", 0, logger)
+	if config.UnmountOnShutdown {
+		lifecycle.OnShutdown("unmount volumes", driver.unmountAll)
+	}
+	lifecycle.OnShutdown("close database", driver.shutdown)
+
+	os.Exit(lifecycle.Serve(volume.NewHandler(driver)))
+}
+
+// checkDriver creates a driver without changing the volume database, which is
+// opened read-only. If it does not exist yet, a throwaway one is used instead
+func checkDriver(config *config, logger *common.Logger) error {
+	checkConfig := *config
+	checkConfig.ReadOnly = true
+
+	_, err := os.Stat(config.DatabasePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		dir, err := os.MkdirTemp("", "cifs-volume-plugin-check-*")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+
+		checkConfig.DatabasePath = path.Join(dir, DefaultDatabaseName)
+		checkConfig.ReadOnly = false
+	}
+
+	driver, err := newDriverFromConfig(&checkConfig, logger)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		return err
 	}
 
-	handler := volume.NewHandler(driver)
-	if err := handler.ServeUnix("smbfs", 0); err != nil {
-		log.Fatal(err)
+	return driver.Close()
+}
+
+// newDriverFromConfig creates a driver using the settings and the kernel
+// mount table
+func newDriverFromConfig(config *config, logger *common.Logger) (*cifsDriver, error) {
+	mounter, err := NewMounter(config.Mounter)
+	if err != nil {
+		return nil, &common.ConfigError{Err: err}
+	}
+
+	fd, err := os.Open(mount.ProcMountsPath)
+	if err != nil {
+		return nil, &common.BackendUnavailable{Backend: "mount table", Err: err}
 	}
+	defer fd.Close()
+
+	mounts, err := mount.NewMountsCache(fd)
+	if err != nil {
+		return nil, &common.BackendUnavailable{Backend: "mount table", Err: err}
+	}
+
+	return NewDriver(config, mounter, mounts, logger)
 }
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"unicode"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// MounterSyscall mounts shares in-process through the mount system call
+	MounterSyscall string = "syscall"
+	// MounterExec mounts shares using the mount and umount binaries
+	MounterExec string = "exec"
+)
+
+// Mounter mounts and unmounts CIFS shares. Credentials are nil on anonymous
+// mounts, and must never be part of a command line
+type Mounter interface {
+	Mount(source string, target string, options Options, credentials *Credentials) error
+	Unmount(target string) error
+}
+
+// MountError describes a failed mount operation
+type MountError struct {
+	Op     string
+	Source string
+	Target string
+	// Output contains the mount helper output, if any
+	Output string
+	Err    error
+}
+
+func (err *MountError) Error() string {
+	var message string
+	if err.Source != "" {
+		message = fmt.Sprintf("%s %s on %s: %s", err.Op, err.Source, err.Target, err.Err)
+	} else {
+		message = fmt.Sprintf("%s %s: %s", err.Op, err.Target, err.Err)
+	}
+
+	if errno, ok := err.Errno(); ok {
+		message = fmt.Sprintf("%s (errno %d)", message, errno)
+	}
+
+	if err.Output != "" {
+		message = fmt.Sprintf("%s: %s", message, err.Output)
+	}
+
+	return message
+}
+
+func (err *MountError) Unwrap() error {
+	return err.Err
+}
+
+// Errno returns the kernel error number that caused the failure, if known
+func (err *MountError) Errno() (syscall.Errno, bool) {
+	var errno syscall.Errno
+	ok := errors.As(err.Err, &errno)
+	return errno, ok
+}
+
+// NewMounter creates a mounter by its kind
+func NewMounter(kind string) (Mounter, error) {
+	switch kind {
+	case "", MounterSyscall:
+		return &syscallMounter{
+			resolver: net.DefaultResolver,
+		}, nil
+	case MounterExec:
+		return &execMounter{}, nil
+	default:
+		return nil, fmt.Errorf("unknown mounter %q", kind)
+	}
+}
+
+// addressOptions set the server address apart from the UNC host. Mounters drop
+// those, so the credentials chosen by the host are never sent to another server
+var addressOptions = map[string]bool{
+	"addr": true,
+	"ip":   true,
+}
+
+// syscallMounter mounts shares without external helpers, doing in-process the
+// same preparation mount.cifs does before calling the kernel
+type syscallMounter struct {
+	resolver *net.Resolver
+}
+
+// mountFlags maps the generic mount options to the kernel flags they set. The
+// options mapped to zero are either defaults or userspace-only, and are dropped
+var mountFlags = map[string]uintptr{
+	"_netdev":     0,
+	"async":       0,
+	"atime":       0,
+	"auto":        0,
+	"defaults":    0,
+	"dev":         0,
+	"diratime":    0,
+	"exec":        0,
+	"noauto":      0,
+	"nofail":      0,
+	"nolazytime":  0,
+	"norelatime":  0,
+	"rw":          0,
+	"suid":        0,
+	"dirsync":     unix.MS_DIRSYNC,
+	"lazytime":    unix.MS_LAZYTIME,
+	"noatime":     unix.MS_NOATIME,
+	"nodev":       unix.MS_NODEV,
+	"nodiratime":  unix.MS_NODIRATIME,
+	"noexec":      unix.MS_NOEXEC,
+	"nosuid":      unix.MS_NOSUID,
+	"relatime":    unix.MS_RELATIME,
+	"ro":          unix.MS_RDONLY,
+	"strictatime": unix.MS_STRICTATIME,
+	"sync":        unix.MS_SYNCHRONOUS,
+}
+
+func (mounter *syscallMounter) Mount(source string, target string, options Options, credentials *Credentials) error {
+	flags, data, err := mounter.kernelOptions(source, options, credentials)
+	if err != nil {
+		return &MountError{Op: "mount", Source: source, Target: target, Err: err}
+	}
+
+	err = unix.Mount(source, target, "cifs", flags, data)
+	if err != nil {
+		return &MountError{Op: "mount", Source: source, Target: target, Err: err}
+	}
+
+	return nil
+}
+
+func (mounter *syscallMounter) Unmount(target string) error {
+	err := unix.Unmount(target, 0)
+	if err != nil {
+		return &MountError{Op: "umount", Target: target, Err: err}
+	}
+
+	return nil
+}
+
+// kernelOptions converts the UNC source and mount options to the flags and
+// data the cifs kernel module expects
+func (mounter *syscallMounter) kernelOptions(source string, options Options, credentials *Credentials) (uintptr, string, error) {
+	unc, err := ParseUNC(source)
+	if err != nil {
+		return 0, "", err
+	}
+
+	addresses, err := mounter.resolver.LookupHost(context.Background(), unc.Host)
+	if err != nil {
+		return 0, "", err
+	}
+
+	var flags uintptr
+	data := make(Options, len(options)+2)
+
+	for key, value := range options {
+		if addressOptions[key] {
+			continue
+		}
+
+		flag, generic := mountFlags[key]
+		if generic {
+			flags |= flag
+			continue
+		}
+
+		data[key] = value
+	}
+
+	if credentials != nil {
+		for key, value := range map[string]string{
+			"username": credentials.Username,
+			"password": credentials.Password,
+			"domain":   credentials.Domain,
+		} {
+			if value == "" {
+				continue
+			}
+
+			// only the password supports escaping, other values would inject
+			// extra options
+			if key != "password" {
+				err = checkDataValue(key, value)
+				if err != nil {
+					return 0, "", err
+				}
+			}
+
+			data[key] = value
+		}
+	}
+
+	data["unc"] = fmt.Sprintf(`\\%s\%s`, unc.Host, unc.Share)
+	data["ip"] = addresses[0]
+
+	if unc.Path != "" {
+		data["prefixpath"] = unc.Path
+	}
+
+	if _, exists := data["port"]; !exists && unc.Port != 0 {
+		data["port"] = strconv.Itoa(unc.Port)
+	}
+
+	// the kernel parser splits options on commas, and takes doubled ones as an
+	// escaped literal comma on passwords
+	if password, exists := data["password"]; exists {
+		data["password"] = strings.ReplaceAll(password, ",", ",,")
+	}
+
+	return flags, data.String(), nil
+}
+
+// checkDataValue rejects values that would split the kernel mount data
+func checkDataValue(key string, value string) error {
+	if strings.Contains(value, ",") {
+		return fmt.Errorf("%s must not contain commas", key)
+	}
+
+	for _, char := range value {
+		if unicode.IsControl(char) {
+			return fmt.Errorf("%s must not contain control characters", key)
+		}
+	}
+
+	return nil
+}
+
+// execMounter mounts shares using the mount and umount binaries, which must
+// support the cifs type
+type execMounter struct{}
+
+// mountHelperError matches the kernel error number mount.cifs reports
+var mountHelperError = regexp.MustCompile(`mount error\((\d+)\)`)
+
+func (mounter *execMounter) Mount(source string, target string, options Options, credentials *Credentials) error {
+	unc, err := ParseUNC(source)
+	if err != nil {
+		return &MountError{Op: "mount", Source: source, Target: target, Err: err}
+	}
+
+	if credentials != nil {
+		credentialsFile, err := writeCredentialsFile(credentials)
+		if err != nil {
+			return &MountError{Op: "mount", Source: source, Target: target, Err: err}
+		}
+		defer os.Remove(credentialsFile)
+
+		options = mergeOptions(options, Options{"credentials": credentialsFile})
+	}
+
+	output, err := exec.Command("mount", mounter.mountArgs(unc, target, options)...).CombinedOutput()
+	if err != nil {
+		return &MountError{Op: "mount", Source: source, Target: target, Output: helperOutput(output), Err: helperError(output, err)}
+	}
+
+	return nil
+}
+
+// mountArgs builds the mount command arguments, without the address options
+func (mounter *execMounter) mountArgs(unc *UNC, target string, options Options) []string {
+	helperOptions := make(Options, len(options)+1)
+
+	for key, value := range options {
+		if addressOptions[key] {
+			continue
+		}
+
+		helperOptions[key] = value
+	}
+
+	// mount.cifs takes the port as an option only
+	if _, exists := helperOptions["port"]; !exists && unc.Port != 0 {
+		helperOptions["port"] = strconv.Itoa(unc.Port)
+	}
+
+	return []string{"-t", "cifs", "-o", helperOptions.String(), unc.Source(), target}
+}
+
+// writeCredentialsFile stores the credentials on a temporary file readable
+// only by the plugin, so mount.cifs reads those instead of the command line
+func writeCredentialsFile(credentials *Credentials) (string, error) {
+	data, err := credentials.MarshalText()
+	if err != nil {
+		return "", err
+	}
+
+	fd, err := os.CreateTemp("", "cifs-credentials-*")
+	if err != nil {
+		return "", err
+	}
+	defer fd.Close()
+
+	_, err = fd.Write(data)
+	if err != nil {
+		os.Remove(fd.Name())
+		return "", err
+	}
+
+	return fd.Name(), nil
+}
+
+func (mounter *execMounter) Unmount(target string) error {
+	output, err := exec.Command("umount", target).CombinedOutput()
+	if err != nil {
+		return &MountError{Op: "umount", Target: target, Output: helperOutput(output), Err: helperError(output, err)}
+	}
+
+	return nil
+}
+
+func helperOutput(output []byte) string {
+	return strings.TrimSpace(string(output))
+}
+
+// helperError extracts the kernel error number from the helper output, falling
+// back to the execution error when there is none
+func helperError(output []byte, err error) error {
+	matches := mountHelperError.FindSubmatch(output)
+	if matches == nil {
+		return err
+	}
+
+	errno, parseErr := strconv.Atoi(string(matches[1]))
+	if parseErr != nil {
+		return err
+	}
+
+	return syscall.Errno(errno)
+}
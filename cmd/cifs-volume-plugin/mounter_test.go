@@ -0,0 +1,323 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/wwmoraes/docker-engine-plugins/internal/mount"
+	"golang.org/x/sys/unix"
+)
+
+func TestSyscallMounter_kernelOptions(t *testing.T) {
+	mounter := &syscallMounter{
+		resolver: net.DefaultResolver,
+	}
+
+	type args struct {
+		source      string
+		options     Options
+		credentials *Credentials
+	}
+	tests := []struct {
+		name      string
+		args      args
+		wantFlags uintptr
+		wantData  map[string]string
+		wantErr   bool
+	}{
+		{
+			name: "share",
+			args: args{
+				source:  "//10.0.0.2/share",
+				options: Options{"vers": "3.0"},
+			},
+			wantData: map[string]string{
+				"unc":  `\\10.0.0.2\share`,
+				"ip":   "10.0.0.2",
+				"vers": "3.0",
+			},
+		},
+		{
+			name: "share subpath and generic flags",
+			args: args{
+				source:  "//10.0.0.2/share/sub/path",
+				options: Options{"ro": "", "nosuid": "", "rw": ""},
+			},
+			wantFlags: unix.MS_RDONLY | unix.MS_NOSUID,
+			wantData: map[string]string{
+				"unc":        `\\10.0.0.2\share`,
+				"ip":         "10.0.0.2",
+				"prefixpath": "sub/path",
+			},
+		},
+		{
+			name: "credentials",
+			args: args{
+				source:      "//10.0.0.2/share",
+				credentials: &Credentials{Username: "foo", Password: "secret", Domain: "WORKGROUP"},
+			},
+			wantData: map[string]string{
+				"unc":      `\\10.0.0.2\share`,
+				"ip":       "10.0.0.2",
+				"username": "foo",
+				"password": "secret",
+				"domain":   "WORKGROUP",
+			},
+		},
+		{
+			name: "port",
+			args: args{
+				source:      "//10.0.0.2:4455/share",
+				credentials: &Credentials{Username: "foo"},
+			},
+			wantData: map[string]string{
+				"unc":      `\\10.0.0.2\share`,
+				"ip":       "10.0.0.2",
+				"port":     "4455",
+				"username": "foo",
+			},
+		},
+		{
+			name: "address options",
+			args: args{
+				source:      "//10.0.0.2/share",
+				options:     Options{"ip": "10.0.0.66", "addr": "10.0.0.66"},
+				credentials: &Credentials{Username: "foo"},
+			},
+			wantData: map[string]string{
+				"unc":      `\\10.0.0.2\share`,
+				"ip":       "10.0.0.2",
+				"username": "foo",
+			},
+		},
+		{
+			name: "missing share",
+			args: args{
+				source: "//10.0.0.2",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags, data, err := mounter.kernelOptions(tt.args.source, tt.args.options, tt.args.credentials)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("syscallMounter.kernelOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if flags != tt.wantFlags {
+				t.Errorf("syscallMounter.kernelOptions() flags = %#x, want %#x", flags, tt.wantFlags)
+			}
+
+			gotData, err := mount.NewMountOptions([]byte(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(map[string]string(*gotData), tt.wantData) {
+				t.Errorf("syscallMounter.kernelOptions() data = %v, want %v", *gotData, tt.wantData)
+			}
+		})
+	}
+}
+
+func TestSyscallMounter_kernelOptions_passwordComma(t *testing.T) {
+	mounter := &syscallMounter{
+		resolver: net.DefaultResolver,
+	}
+
+	_, data, err := mounter.kernelOptions("//10.0.0.2/share", Options{}, &Credentials{Password: "foo,bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(data, "password=foo,,bar") {
+		t.Errorf("syscallMounter.kernelOptions() data = %q, want escaped password comma", data)
+	}
+}
+
+func TestSyscallMounter_kernelOptions_injectedCredentials(t *testing.T) {
+	mounter := &syscallMounter{
+		resolver: net.DefaultResolver,
+	}
+
+	tests := []struct {
+		name        string
+		credentials *Credentials
+	}{
+		{"username comma", &Credentials{Username: "user,uid=0", Password: "secret"}},
+		{"domain comma", &Credentials{Username: "user", Domain: "CORP,uid=0"}},
+		{"username control character", &Credentials{Username: "user\x00uid=0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, data, err := mounter.kernelOptions("//10.0.0.2/share", Options{}, tt.credentials)
+			if err == nil {
+				t.Errorf("syscallMounter.kernelOptions() data = %q, want error", data)
+			}
+		})
+	}
+}
+
+func TestExecMounter_mountArgs(t *testing.T) {
+	mounter := &execMounter{}
+
+	tests := []struct {
+		name    string
+		source  string
+		options Options
+		want    []string
+	}{
+		{
+			name:    "share",
+			source:  "//host/share/sub",
+			options: Options{"vers": "3.0"},
+			want:    []string{"-t", "cifs", "-o", "vers=3.0", "//host/share/sub", "/mnt"},
+		},
+		{
+			name:    "port",
+			source:  "//host:4455/share",
+			options: Options{},
+			want:    []string{"-t", "cifs", "-o", "port=4455", "//host/share", "/mnt"},
+		},
+		{
+			name:    "address options",
+			source:  "//host/share",
+			options: Options{"ip": "10.0.0.66", "addr": "10.0.0.66", "vers": "3.0"},
+			want:    []string{"-t", "cifs", "-o", "vers=3.0", "//host/share", "/mnt"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unc, err := ParseUNC(tt.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := mounter.mountArgs(unc, "/mnt", tt.options)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("execMounter.mountArgs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMountError(t *testing.T) {
+	err := error(&MountError{
+		Op:     "mount",
+		Source: "//host/share",
+		Target: "/mnt",
+		Err:    syscall.EACCES,
+	})
+
+	want := "mount //host/share on /mnt: permission denied (errno 13)"
+	if err.Error() != want {
+		t.Errorf("MountError.Error() = %q, want %q", err.Error(), want)
+	}
+
+	if !errors.Is(err, syscall.EACCES) {
+		t.Errorf("MountError does not unwrap to the errno")
+	}
+}
+
+func Test_helperError(t *testing.T) {
+	fallback := errors.New("exit status 32")
+
+	tests := []struct {
+		name   string
+		output string
+		want   error
+	}{
+		{
+			name:   "mount.cifs error",
+			output: "mount error(13): Permission denied\nRefer to the mount.cifs(8) manual page",
+			want:   syscall.EACCES,
+		},
+		{
+			name:   "unknown output",
+			output: "mount: mounting //host/share on /mnt failed: Invalid argument",
+			want:   fallback,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := helperError([]byte(tt.output), fallback); !errors.Is(got, tt.want) {
+				t.Errorf("helperError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewMounter(t *testing.T) {
+	tests := []struct {
+		name    string
+		kind    string
+		want    Mounter
+		wantErr bool
+	}{
+		{
+			name: "default",
+			kind: "",
+			want: &syscallMounter{resolver: net.DefaultResolver},
+		},
+		{
+			name: "syscall",
+			kind: MounterSyscall,
+			want: &syscallMounter{resolver: net.DefaultResolver},
+		},
+		{
+			name: "exec",
+			kind: MounterExec,
+			want: &execMounter{},
+		},
+		{
+			name:    "unknown",
+			kind:    "fuse",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewMounter(tt.kind)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewMounter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewMounter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_writeCredentialsFile(t *testing.T) {
+	name, err := writeCredentialsFile(&Credentials{Username: "foo", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("writeCredentialsFile() mode = %v, want %v", info.Mode().Perm(), os.FileMode(0600))
+	}
+
+	got, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "username=foo\npassword=secret\n"
+	if string(got) != want {
+		t.Errorf("writeCredentialsFile() content = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// knownOptions contains the mount options accepted on volumes, both generic
+// ones and the ones documented on mount.cifs(8)
+var knownOptions = map[string]bool{
+	// generic
+	"_netdev":     true,
+	"async":       true,
+	"atime":       true,
+	"auto":        true,
+	"defaults":    true,
+	"dev":         true,
+	"diratime":    true,
+	"dirsync":     true,
+	"exec":        true,
+	"lazytime":    true,
+	"noatime":     true,
+	"noauto":      true,
+	"nodev":       true,
+	"nodiratime":  true,
+	"noexec":      true,
+	"nofail":      true,
+	"nolazytime":  true,
+	"norelatime":  true,
+	"nosuid":      true,
+	"relatime":    true,
+	"ro":          true,
+	"rw":          true,
+	"strictatime": true,
+	"suid":        true,
+	"sync":        true,
+	// cifs
+	"acdirmax":            true,
+	"acregmax":            true,
+	"actimeo":             true,
+	"addr":                true,
+	"backupgid":           true,
+	"backupuid":           true,
+	"brl":                 true,
+	"bsize":               true,
+	"cache":               true,
+	"cifsacl":             true,
+	"closetimeo":          true,
+	"credentials":         true,
+	"cred":                true,
+	"cruid":               true,
+	"dir_mode":            true,
+	"dom":                 true,
+	"domain":              true,
+	"domainauto":          true,
+	"echo_interval":       true,
+	"file_mode":           true,
+	"forcegid":            true,
+	"forcemandatorylock":  true,
+	"forceuid":            true,
+	"fsc":                 true,
+	"gid":                 true,
+	"guest":               true,
+	"handlecache":         true,
+	"handletimeout":       true,
+	"hard":                true,
+	"ignorecase":          true,
+	"intr":                true,
+	"iocharset":           true,
+	"ip":                  true,
+	"linux":               true,
+	"locallease":          true,
+	"mapchars":            true,
+	"mapposix":            true,
+	"max_cached_dirs":     true,
+	"max_channels":        true,
+	"max_credits":         true,
+	"mfsymlinks":          true,
+	"multichannel":        true,
+	"multiuser":           true,
+	"netbiosname":         true,
+	"noacl":               true,
+	"noautotune":          true,
+	"noblocksend":         true,
+	"nobrl":               true,
+	"nocase":              true,
+	"nocifsacl":           true,
+	"nodfs":               true,
+	"noforcegid":          true,
+	"noforceuid":          true,
+	"nohandlecache":       true,
+	"nointr":              true,
+	"nolease":             true,
+	"nolinux":             true,
+	"nomapchars":          true,
+	"nomapposix":          true,
+	"nomultichannel":      true,
+	"noperm":              true,
+	"nopersistenthandles": true,
+	"noposix":             true,
+	"noposixpaths":        true,
+	"noresilienthandles":  true,
+	"noserverino":         true,
+	"nosetuids":           true,
+	"nosharesock":         true,
+	"nostrictsync":        true,
+	"nounix":              true,
+	"nouser_xattr":        true,
+	"pass":                true,
+	"password":            true,
+	"perm":                true,
+	"persistenthandles":   true,
+	"port":                true,
+	"posix":               true,
+	"posixpaths":          true,
+	"rdma":                true,
+	"resilienthandles":    true,
+	"rsize":               true,
+	"rwpidforward":        true,
+	"seal":                true,
+	"sec":                 true,
+	"serverino":           true,
+	"servern":             true,
+	"setuids":             true,
+	"sfu":                 true,
+	"snapshot":            true,
+	"soft":                true,
+	"strictsync":          true,
+	"uid":                 true,
+	"unix":                true,
+	"user":                true,
+	"user_xattr":          true,
+	"username":            true,
+	"vers":                true,
+	"wsize":               true,
+}
+
+// passwordOptions are never accepted on volumes, as those would store the
+// plaintext password. Volumes use a credentials source instead
+var passwordOptions = map[string]bool{
+	"pass":     true,
+	"password": true,
+}
+
+// sensitiveOptions set or point to credentials, and are only accepted on
+// volumes if allowed on the plugin settings
+var sensitiveOptions = map[string]bool{
+	"cred":                  true,
+	"credentials":           true,
+	CredentialsSourceOption: true,
+	"dom":                   true,
+	"domain":                true,
+	"pass":                  true,
+	"password":              true,
+	"user":                  true,
+	"username":              true,
+}
+
+// unsafeOptions are never accepted on volumes. ip and addr would send the
+// credentials chosen by the share host to another server, and cruid would use
+// the Kerberos credentials of another user
+var unsafeOptions = map[string]bool{
+	"addr":  true,
+	"cruid": true,
+	"ip":    true,
+}
+
+// invalidValueCharacters would allow a value to inject extra mount options
+const invalidValueCharacters = ",="
+
+// validateOptions checks that all option keys are known mount options,
+// including the negated ones, and that no value can inject other options
+func validateOptions(options Options) error {
+	for key, value := range options {
+		name := strings.TrimPrefix(key, negatePrefix)
+		if !knownOptions[name] {
+			return fmt.Errorf("unknown mount option %q", key)
+		}
+
+		if strings.ContainsAny(value, invalidValueCharacters) {
+			return fmt.Errorf("mount option %q value must not contain any of %q", key, invalidValueCharacters)
+		}
+
+		for _, char := range value {
+			if unicode.IsControl(char) {
+				return fmt.Errorf("mount option %q value must not contain control characters", key)
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseAllowedOptions parses a comma-separated list of sensitive option keys
+func parseAllowedOptions(value string) (map[string]bool, error) {
+	allowed := make(map[string]bool)
+
+	for _, key := range strings.Split(value, ",") {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+
+		if unsafeOptions[key] {
+			return nil, fmt.Errorf("option %q cannot be allowed on volumes", key)
+		}
+
+		if !sensitiveOptions[key] {
+			return nil, fmt.Errorf("option %q is not a sensitive option", key)
+		}
+
+		if passwordOptions[key] {
+			return nil, fmt.Errorf("option %q cannot be allowed, use %s instead", key, CredentialsSourceOption)
+		}
+
+		allowed[key] = true
+	}
+
+	return allowed, nil
+}
+
+// checkDefaultOptions rejects the default options that are never allowed,
+// which the plugin settings cannot enable either. Defaults are stored on every
+// volume record, so they can't hold a password either
+func checkDefaultOptions(options Options) error {
+	for key := range options {
+		name := strings.TrimPrefix(key, negatePrefix)
+		if unsafeOptions[name] {
+			return fmt.Errorf("mount option %q is never allowed", key)
+		}
+
+		if passwordOptions[name] {
+			return fmt.Errorf("mount option %q is never allowed, use a credentials file instead", key)
+		}
+	}
+
+	return nil
+}
+
+// checkSensitiveOptions rejects the unsafe options, and the sensitive ones not
+// explicitly allowed
+func checkSensitiveOptions(options Options, allowed map[string]bool) error {
+	for key := range options {
+		name := strings.TrimPrefix(key, negatePrefix)
+		if unsafeOptions[name] {
+			return fmt.Errorf("mount option %q is never allowed on volumes", key)
+		}
+
+		if sensitiveOptions[name] && !allowed[name] {
+			return fmt.Errorf("mount option %q is not allowed on volumes", key)
+		}
+	}
+
+	return nil
+}
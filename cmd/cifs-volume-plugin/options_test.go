@@ -0,0 +1,166 @@
+package main
+
+import "testing"
+
+func Test_validateOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+		wantErr bool
+	}{
+		{
+			name:    "known options",
+			options: Options{"vers": "3.0", "uid": "1000", "ro": "", "noperm": ""},
+		},
+		{
+			name:    "negated known option",
+			options: Options{"!noperm": ""},
+		},
+		{
+			name:    "typo",
+			options: Options{"vesr": "3.0"},
+			wantErr: true,
+		},
+		{
+			name:    "negated unknown option",
+			options: Options{"!foo": ""},
+			wantErr: true,
+		},
+		{
+			name:    "comma injecting credentials",
+			options: Options{"vers": "3.0,credentials=/etc/shadow"},
+			wantErr: true,
+		},
+		{
+			name:    "comma injecting uid",
+			options: Options{"gid": "1000,uid=0"},
+			wantErr: true,
+		},
+		{
+			name:    "equal sign in value",
+			options: Options{"iocharset": "utf8=uid=0"},
+			wantErr: true,
+		},
+		{
+			name:    "newline in value",
+			options: Options{"iocharset": "utf8\nuid"},
+			wantErr: true,
+		},
+		{
+			name:    "null byte in value",
+			options: Options{"iocharset": "utf8\x00uid"},
+			wantErr: true,
+		},
+		{
+			name:    "injected key",
+			options: Options{"vers=3.0,uid": "0"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateOptions(tt.options); (err != nil) != tt.wantErr {
+				t.Errorf("validateOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_checkSensitiveOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+		allowed string
+		wantErr bool
+	}{
+		{
+			name:    "regular options",
+			options: Options{"vers": "3.0", "uid": "1000"},
+		},
+		{
+			name:    "credentials",
+			options: Options{"credentials": "/etc/shadow"},
+			wantErr: true,
+		},
+		{
+			name:    "credentials alias",
+			options: Options{"cred": "/etc/shadow"},
+			wantErr: true,
+		},
+		{
+			name:    "password",
+			options: Options{"password": "secret"},
+			wantErr: true,
+		},
+		{
+			name:    "password alias",
+			options: Options{"pass": "secret"},
+			wantErr: true,
+		},
+		{
+			name:    "username",
+			options: Options{"username": "admin"},
+			wantErr: true,
+		},
+		{
+			name:    "username alias",
+			options: Options{"user": "admin"},
+			wantErr: true,
+		},
+		{
+			name:    "negated username",
+			options: Options{"!username": ""},
+			wantErr: true,
+		},
+		{
+			name:    "server address",
+			options: Options{"service": "//trusted-host/foo", "ip": "10.0.0.66"},
+			wantErr: true,
+		},
+		{
+			name:    "server address alias",
+			options: Options{"addr": "10.0.0.66"},
+			wantErr: true,
+		},
+		{
+			name:    "credentials cache user",
+			options: Options{"cruid": "0"},
+			wantErr: true,
+		},
+		{
+			name:    "allowed username",
+			options: Options{"username": "admin", "domain": "WORKGROUP"},
+			allowed: "username, domain",
+		},
+		{
+			name:    "allowed username only",
+			options: Options{"username": "admin", "password": "secret"},
+			allowed: "username",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed, err := parseAllowedOptions(tt.allowed)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := checkSensitiveOptions(tt.options, allowed); (err != nil) != tt.wantErr {
+				t.Errorf("checkSensitiveOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_parseAllowedOptions(t *testing.T) {
+	_, err := parseAllowedOptions("username,uid")
+	if err == nil {
+		t.Errorf("parseAllowedOptions() error = nil, want error on a non-sensitive option")
+	}
+
+	_, err = parseAllowedOptions("username,ip")
+	if err == nil {
+		t.Errorf("parseAllowedOptions() error = nil, want error on an unsafe option")
+	}
+}
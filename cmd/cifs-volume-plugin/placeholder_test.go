@@ -1,7 +0,0 @@
-package main
-
-import "testing"
-
-func Test_placeholder(t *testing.T) {
-	t.Skip("TODO code smbfs plugin and test it")
-}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/mitchellh/mapstructure"
+	bolt "go.etcd.io/bbolt"
+)
+
+// SchemaVersion is the volume records schema version this driver writes
+const SchemaVersion = 1
+
+var (
+	volumeBucket   = []byte("volumes")
+	metadataBucket = []byte("metadata")
+	// schemaVersionKey holds the records schema version as a decimal string
+	schemaVersionKey = []byte("schema-version")
+)
+
+// maxHistory limits the mount events kept per volume
+const maxHistory = 10
+
+// migrations upgrade the records from the schema version of its index to the
+// next one, within the same transaction
+var migrations = []func(driver *cifsDriver, tx *bolt.Tx) error{
+	0: (*cifsDriver).migrateGobRecords,
+}
+
+func init() {
+	// legacy gob records store the status values as interfaces, so gob needs to
+	// know the concrete types to decode them
+	gob.Register(Options{})
+	gob.Register(References{})
+}
+
+// volumeRecord is the stored volume state, JSON-encoded on the volumes bucket
+type volumeRecord struct {
+	Name       string `json:"name"`
+	Mountpoint string `json:"mountpoint"`
+	Status
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	// History contains the latest share mount events, oldest first
+	History []mountEvent `json:"history,omitempty"`
+}
+
+// mountEvent records a change on the share mount state
+type mountEvent struct {
+	Op   string    `json:"op"`
+	ID   string    `json:"id,omitempty"`
+	Time time.Time `json:"time"`
+}
+
+// Volume returns the record as the volume plugin API expects it
+func (record *volumeRecord) Volume() (*volume.Volume, error) {
+	status := make(map[string]interface{})
+	err := mapstructure.Decode(record.Status, &status)
+	if err != nil {
+		return nil, err
+	}
+
+	return &volume.Volume{
+		Name:       record.Name,
+		Mountpoint: record.Mountpoint,
+		CreatedAt:  record.CreatedAt.Format(time.RFC3339),
+		Status:     status,
+	}, nil
+}
+
+// addEvent appends a mount event to the history, dropping the oldest ones
+func (record *volumeRecord) addEvent(op string, id string, at time.Time) {
+	record.History = append(record.History, mountEvent{Op: op, ID: id, Time: at})
+
+	if len(record.History) > maxHistory {
+		record.History = record.History[len(record.History)-maxHistory:]
+	}
+}
+
+// schemaVersion returns the records schema version of the database
+func schemaVersion(tx *bolt.Tx) (int, error) {
+	if metadata := tx.Bucket(metadataBucket); metadata != nil {
+		if value := metadata.Get(schemaVersionKey); value != nil {
+			version, err := strconv.Atoi(string(value))
+			if err != nil {
+				return 0, fmt.Errorf("invalid schema version %q: %w", value, err)
+			}
+
+			return version, nil
+		}
+	}
+
+	// databases without metadata predate the versioned schema
+	if volumes := tx.Bucket(volumeBucket); volumes != nil && volumes.Stats().KeyN > 0 {
+		return 0, nil
+	}
+
+	return SchemaVersion, nil
+}
+
+// migrate upgrades the database records to the current schema version. On
+// read-only mode it only checks that no migration is needed
+func (driver *cifsDriver) migrate() error {
+	if driver.readOnly {
+		return driver.db.View(func(tx *bolt.Tx) error {
+			version, err := schemaVersion(tx)
+			if err != nil {
+				return err
+			}
+
+			if version != SchemaVersion || tx.Bucket(volumeBucket) == nil {
+				return fmt.Errorf("database schema version %d needs a migration to %d, which is not possible on read-only mode", version, SchemaVersion)
+			}
+
+			return nil
+		})
+	}
+
+	return driver.db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(volumeBucket)
+		if err != nil {
+			return err
+		}
+
+		metadata, err := tx.CreateBucketIfNotExists(metadataBucket)
+		if err != nil {
+			return err
+		}
+
+		version, err := schemaVersion(tx)
+		if err != nil {
+			return err
+		}
+
+		if version > SchemaVersion {
+			return fmt.Errorf("database schema version %d is newer than the supported %d", version, SchemaVersion)
+		}
+
+		for ; version < SchemaVersion; version++ {
+			driver.logger.Info("migrating volume records", "from", version, "to", version+1)
+
+			err = migrations[version](driver, tx)
+			if err != nil {
+				return fmt.Errorf("failed to migrate from schema version %d: %w", version, err)
+			}
+		}
+
+		return metadata.Put(schemaVersionKey, []byte(strconv.Itoa(SchemaVersion)))
+	})
+}
+
+// migrateGobRecords converts the gob-encoded volumes with a free-form status
+// to JSON records
+func (driver *cifsDriver) migrateGobRecords(tx *bolt.Tx) error {
+	bucket := tx.Bucket(volumeBucket)
+	records := make(map[string]*volumeRecord)
+
+	err := bucket.ForEach(func(k, v []byte) error {
+		var info volume.Volume
+
+		err := gob.NewDecoder(bytes.NewReader(v)).Decode(&info)
+		if err != nil {
+			return fmt.Errorf("volume %s: %w", string(k), err)
+		}
+
+		record := &volumeRecord{
+			Name:       info.Name,
+			Mountpoint: info.Mountpoint,
+			UpdatedAt:  driver.now(),
+		}
+
+		err = mapstructure.Decode(info.Status, &record.Status)
+		if err != nil {
+			return fmt.Errorf("volume %s: %w", string(k), err)
+		}
+
+		record.CreatedAt, err = parseLegacyTime(info.CreatedAt)
+		if err != nil {
+			driver.logger.Warn("invalid creation time, using the current time", "volume", info.Name, "created_at", info.CreatedAt)
+			record.CreatedAt = record.UpdatedAt
+		}
+
+		records[string(k)] = record
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for name, record := range records {
+		err = putRecord(bucket, name, record)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseLegacyTime parses the time.Time String format older versions used on
+// the volume creation time, ignoring the monotonic clock reading if present
+func parseLegacyTime(value string) (time.Time, error) {
+	if index := strings.Index(value, " m="); index >= 0 {
+		value = value[:index]
+	}
+
+	return time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", value)
+}
+
+func putRecord(bucket *bolt.Bucket, name string, record *volumeRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return bucket.Put([]byte(name), data)
+}
+
+func decodeRecord(data []byte) (*volumeRecord, error) {
+	var record volumeRecord
+
+	err := json.Unmarshal(data, &record)
+	if err != nil {
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+// inspect writes the stored volume records as JSON lines
+func (driver *cifsDriver) inspect(w io.Writer) error {
+	return driver.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(volumeBucket)
+		if bucket == nil {
+			return fmt.Errorf("bucket %s not found", string(volumeBucket))
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			_, err := fmt.Fprintf(w, "%s\n", v)
+			return err
+		})
+	})
+}
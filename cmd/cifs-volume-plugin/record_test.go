@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/mitchellh/mapstructure"
+	"github.com/wwmoraes/docker-engine-plugins/internal/common"
+	bolt "go.etcd.io/bbolt"
+)
+
+// writeLegacyDatabase stores the volumes as gob records without metadata, as
+// older versions did
+func writeLegacyDatabase(tb testing.TB, name string, volumes ...*volume.Volume) {
+	tb.Helper()
+
+	db, err := bolt.Open(name, 0640, nil)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer db.Close()
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(volumeBucket)
+		if err != nil {
+			return err
+		}
+
+		for _, info := range volumes {
+			var data bytes.Buffer
+			err = gob.NewEncoder(&data).Encode(info)
+			if err != nil {
+				return err
+			}
+
+			err = bucket.Put([]byte(info.Name), data.Bytes())
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func TestCifsDriver_migrate(t *testing.T) {
+	dir := t.TempDir()
+
+	status := make(map[string]interface{})
+	err := mapstructure.Decode(Status{
+		Service:           "//host/foo",
+		Options:           Options{"vers": "3.0"},
+		CredentialsSource: "secret:foo",
+		References:        References{},
+	}, &status)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	createdAt := time.Date(2021, time.March, 4, 5, 6, 7, 0, time.UTC)
+	writeLegacyDatabase(t, path.Join(dir, "cifs.db"), &volume.Volume{
+		Name:      "foo",
+		CreatedAt: createdAt.String(),
+		Status:    status,
+	})
+
+	driver := newTestDriver(t, dir, &fakeMounter{}, fakeMountTable{})
+
+	record, err := driver.getRecord("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Status{
+		Service:           "//host/foo",
+		Options:           Options{"vers": "3.0"},
+		CredentialsSource: "secret:foo",
+		References:        References{},
+	}
+	if !reflect.DeepEqual(record.Status, want) {
+		t.Errorf("cifsDriver.migrate() status = %#+v, want %#+v", record.Status, want)
+	}
+
+	if !record.CreatedAt.Equal(createdAt) {
+		t.Errorf("cifsDriver.migrate() CreatedAt = %v, want %v", record.CreatedAt, createdAt)
+	}
+
+	// reconcile moves the mount point out of the container-based path
+	if record.Mountpoint != path.Join(driver.mountRoot, "foo") {
+		t.Errorf("cifsDriver.migrate() Mountpoint = %s, want %s", record.Mountpoint, path.Join(driver.mountRoot, "foo"))
+	}
+
+	err = driver.db.View(func(tx *bolt.Tx) error {
+		version := string(tx.Bucket(metadataBucket).Get(schemaVersionKey))
+		if version != "1" {
+			t.Errorf("cifsDriver.migrate() schema version = %s, want 1", version)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCifsDriver_migrate_newer(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := bolt.Open(path.Join(dir, "cifs.db"), 0640, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(metadataBucket)
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put(schemaVersionKey, []byte("99"))
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewDriver(&config{
+		DatabasePath: path.Join(dir, "cifs.db"),
+	}, &fakeMounter{}, fakeMountTable{}, common.DiscardLogger())
+	if err == nil {
+		t.Errorf("NewDriver() error = nil, want error on a newer schema version")
+	}
+}
+
+func Test_parseLegacyTime(t *testing.T) {
+	want := time.Date(2022, time.June, 1, 12, 0, 0, 123, time.UTC)
+
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{
+			name:  "wall clock",
+			value: want.String(),
+		},
+		{
+			name:  "monotonic clock",
+			value: want.String() + " m=+0.001234567",
+		},
+		{
+			name:    "invalid",
+			value:   "yesterday",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLegacyTime(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseLegacyTime() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !got.Equal(want) {
+				t.Errorf("parseLegacyTime() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func Test_volumeRecord_addEvent(t *testing.T) {
+	record := &volumeRecord{}
+
+	for index := 0; index < maxHistory+2; index++ {
+		record.addEvent("mount", string(rune('a'+index)), mockNow)
+	}
+
+	if len(record.History) != maxHistory {
+		t.Fatalf("volumeRecord.addEvent() history length = %d, want %d", len(record.History), maxHistory)
+	}
+
+	if record.History[0].ID != "c" {
+		t.Errorf("volumeRecord.addEvent() oldest event = %s, want c", record.History[0].ID)
+	}
+}
+
+func TestCifsDriver_inspect(t *testing.T) {
+	driver := newTestDriver(t, t.TempDir(), &fakeMounter{}, fakeMountTable{})
+
+	err := driver.Create(&volume.CreateRequest{
+		Name:    "foo",
+		Options: map[string]string{"service": "//host/foo"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var output bytes.Buffer
+	err = driver.inspect(&output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record, err := decodeRecord(bytes.TrimSuffix(output.Bytes(), []byte("\n")))
+	if err != nil {
+		t.Fatalf("cifsDriver.inspect() output is not a record: %v", err)
+	}
+
+	if record.Name != "foo" || record.Service != "//host/foo" {
+		t.Errorf("cifsDriver.inspect() record = %#+v, want foo", record)
+	}
+}
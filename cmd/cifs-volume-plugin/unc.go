@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// hostnameRegex matches DNS and NetBIOS host names
+var hostnameRegex = regexp.MustCompile(`^[a-zA-Z0-9_]([a-zA-Z0-9_-]*[a-zA-Z0-9_])?(\.[a-zA-Z0-9_]([a-zA-Z0-9_-]*[a-zA-Z0-9_])?)*\.?$`)
+
+// invalidShareCharacters are not allowed on SMB share and path names. Commas
+// are valid on SMB, but would break the mount options apart
+const invalidShareCharacters = "\\/:*?\"<>|,\x00"
+
+// UNC is a parsed //host[:port]/share[/path] location
+type UNC struct {
+	Host string
+	// Port is zero when using the default one
+	Port  int
+	Share string
+	// Path is the optional directory within the share, without leading slashes
+	Path string
+}
+
+// ParseUNC parses and validates a UNC path. IPv6 hosts must be enclosed in
+// brackets, and the share and path segments may be percent-encoded
+func ParseUNC(value string) (*UNC, error) {
+	if !strings.HasPrefix(value, "//") {
+		return nil, fmt.Errorf("invalid UNC path %q: must start with //", value)
+	}
+
+	segments := strings.Split(strings.TrimPrefix(value, "//"), "/")
+	if len(segments) < 2 {
+		return nil, fmt.Errorf("invalid UNC path %q: missing share", value)
+	}
+
+	host, port, err := parseUNCHost(segments[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid UNC path %q: %w", value, err)
+	}
+
+	names := make([]string, 0, len(segments)-1)
+	for index, segment := range segments[1:] {
+		// allows a trailing slash
+		if segment == "" && index > 0 && index == len(segments)-2 {
+			continue
+		}
+
+		name, err := url.PathUnescape(segment)
+		if err != nil {
+			return nil, fmt.Errorf("invalid UNC path %q: %w", value, err)
+		}
+
+		if name == "" || name == "." || name == ".." {
+			return nil, fmt.Errorf("invalid UNC path %q: invalid segment %q", value, segment)
+		}
+
+		if strings.ContainsAny(name, invalidShareCharacters) {
+			return nil, fmt.Errorf("invalid UNC path %q: invalid characters on %q", value, name)
+		}
+
+		names = append(names, name)
+	}
+
+	return &UNC{
+		Host:  host,
+		Port:  port,
+		Share: names[0],
+		Path:  strings.Join(names[1:], "/"),
+	}, nil
+}
+
+func parseUNCHost(value string) (string, int, error) {
+	if value == "" {
+		return "", 0, fmt.Errorf("missing host")
+	}
+
+	host, portValue := value, ""
+	if strings.HasPrefix(value, "[") || strings.Count(value, ":") == 1 {
+		var err error
+		host, portValue, err = net.SplitHostPort(value)
+		if err != nil && strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+			host, portValue, err = strings.Trim(value, "[]"), "", nil
+		}
+		if err != nil {
+			return "", 0, fmt.Errorf("invalid host %q: %w", value, err)
+		}
+	}
+
+	if portValue == "" && strings.HasSuffix(value, ":") {
+		return "", 0, fmt.Errorf("missing port on %q", value)
+	}
+
+	var port int
+	if portValue != "" {
+		var err error
+		port, err = strconv.Atoi(portValue)
+		if err != nil || port < 1 || port > 65535 {
+			return "", 0, fmt.Errorf("invalid port %q", portValue)
+		}
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), port, nil
+	}
+
+	if strings.Contains(value, "[") {
+		return "", 0, fmt.Errorf("invalid IPv6 address %q", host)
+	}
+
+	if !hostnameRegex.MatchString(host) {
+		return "", 0, fmt.Errorf("invalid host %q", host)
+	}
+
+	return host, port, nil
+}
+
+// Address returns the host and port as used on URLs, with IPv6 addresses
+// enclosed in brackets
+func (unc *UNC) Address() string {
+	host := unc.Host
+	if strings.Contains(host, ":") {
+		host = fmt.Sprintf("[%s]", host)
+	}
+
+	if unc.Port == 0 {
+		return host
+	}
+
+	return fmt.Sprintf("%s:%d", host, unc.Port)
+}
+
+// Segments returns the host, share and path segments, without the port
+func (unc *UNC) Segments() []string {
+	segments := []string{unc.Host, unc.Share}
+	if unc.Path != "" {
+		segments = append(segments, strings.Split(unc.Path, "/")...)
+	}
+
+	return segments
+}
+
+// Source returns the decoded UNC path without the port, as mount.cifs expects
+func (unc *UNC) Source() string {
+	host := (&UNC{Host: unc.Host}).Address()
+	return "//" + strings.Join(append([]string{host}, unc.Segments()[1:]...), "/")
+}
+
+// String returns the canonical UNC path, with the share and path segments
+// percent-encoded as needed
+func (unc *UNC) String() string {
+	var path strings.Builder
+
+	path.WriteString("//")
+	path.WriteString(unc.Address())
+
+	for _, segment := range unc.Segments()[1:] {
+		path.WriteString("/")
+		path.WriteString(url.PathEscape(segment))
+	}
+
+	return path.String()
+}
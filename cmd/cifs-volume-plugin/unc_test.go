@@ -0,0 +1,152 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseUNC(t *testing.T) {
+	tests := []struct {
+		name       string
+		value      string
+		want       *UNC
+		wantString string
+		wantSource string
+		wantErr    bool
+	}{
+		{
+			name:       "host and share",
+			value:      "//file-server/foo",
+			want:       &UNC{Host: "file-server", Share: "foo"},
+			wantString: "//file-server/foo",
+			wantSource: "//file-server/foo",
+		},
+		{
+			name:       "port and subpath",
+			value:      "//file-server.local:4455/foo/bar/baz/",
+			want:       &UNC{Host: "file-server.local", Port: 4455, Share: "foo", Path: "bar/baz"},
+			wantString: "//file-server.local:4455/foo/bar/baz",
+			wantSource: "//file-server.local/foo/bar/baz",
+		},
+		{
+			name:       "IPv4",
+			value:      "//10.0.0.2/foo",
+			want:       &UNC{Host: "10.0.0.2", Share: "foo"},
+			wantString: "//10.0.0.2/foo",
+			wantSource: "//10.0.0.2/foo",
+		},
+		{
+			name:       "bracketed IPv6",
+			value:      "//[fe80::1]/foo",
+			want:       &UNC{Host: "fe80::1", Share: "foo"},
+			wantString: "//[fe80::1]/foo",
+			wantSource: "//[fe80::1]/foo",
+		},
+		{
+			name:       "bracketed IPv6 and port",
+			value:      "//[2001:db8::1]:445/foo",
+			want:       &UNC{Host: "2001:db8::1", Port: 445, Share: "foo"},
+			wantString: "//[2001:db8::1]:445/foo",
+			wantSource: "//[2001:db8::1]/foo",
+		},
+		{
+			name:       "bare IPv6",
+			value:      "//2001:db8::1/foo",
+			want:       &UNC{Host: "2001:db8::1", Share: "foo"},
+			wantString: "//[2001:db8::1]/foo",
+			wantSource: "//[2001:db8::1]/foo",
+		},
+		{
+			name:       "percent-encoded",
+			value:      "//host/my%20share/100%25/sub%20dir",
+			want:       &UNC{Host: "host", Share: "my share", Path: "100%/sub dir"},
+			wantString: "//host/my%20share/100%25/sub%20dir",
+			wantSource: "//host/my share/100%/sub dir",
+		},
+		{
+			name:    "missing prefix",
+			value:   "host/foo",
+			wantErr: true,
+		},
+		{
+			name:    "missing host",
+			value:   "///foo",
+			wantErr: true,
+		},
+		{
+			name:    "missing share",
+			value:   "//host",
+			wantErr: true,
+		},
+		{
+			name:    "empty share",
+			value:   "//host/",
+			wantErr: true,
+		},
+		{
+			name:    "empty path segment",
+			value:   "//host/foo//bar",
+			wantErr: true,
+		},
+		{
+			name:    "parent path segment",
+			value:   "//host/foo/../bar",
+			wantErr: true,
+		},
+		{
+			name:    "invalid host",
+			value:   "//host name/foo",
+			wantErr: true,
+		},
+		{
+			name:    "invalid port",
+			value:   "//host:99999/foo",
+			wantErr: true,
+		},
+		{
+			name:    "missing port",
+			value:   "//host:/foo",
+			wantErr: true,
+		},
+		{
+			name:    "invalid bracketed IPv6",
+			value:   "//[host]/foo",
+			wantErr: true,
+		},
+		{
+			name:    "invalid percent-encoding",
+			value:   "//host/foo%zz",
+			wantErr: true,
+		},
+		{
+			name:    "invalid share characters",
+			value:   "//host/foo%2Cbar",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseUNC(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseUNC() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseUNC() = %#+v, want %#+v", got, tt.want)
+			}
+			if got.String() != tt.wantString {
+				t.Errorf("UNC.String() = %s, want %s", got.String(), tt.wantString)
+			}
+			if got.Source() != tt.wantSource {
+				t.Errorf("UNC.Source() = %s, want %s", got.Source(), tt.wantSource)
+			}
+
+			reparsed, err := ParseUNC(got.String())
+			if err != nil || !reflect.DeepEqual(reparsed, got) {
+				t.Errorf("ParseUNC(UNC.String()) = %#+v, %v, want %#+v", reparsed, err, got)
+			}
+		})
+	}
+}
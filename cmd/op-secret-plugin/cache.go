@@ -0,0 +1,114 @@
+package main
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheEntry is a cached lookup result, either a value or a not-found error
+type cacheEntry struct {
+	key     string
+	value   interface{}
+	err     error
+	expires time.Time
+}
+
+// lookupCache is a bounded in-memory cache of Connect lookups. Entries expire
+// after the TTL, and the oldest one is evicted when it is full. A nil cache
+// stores nothing
+type lookupCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	entries    map[string]*list.Element
+	order      *list.List
+	now        func() time.Time
+}
+
+// newLookupCache creates a cache, or returns nil if the ttl or max entries
+// disable it
+func newLookupCache(ttl time.Duration, maxEntries int) *lookupCache {
+	if ttl <= 0 || maxEntries <= 0 {
+		return nil
+	}
+
+	return &lookupCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+		now:        time.Now,
+	}
+}
+
+// cacheKey joins the lookup kind and its arguments, such as the vault ID and
+// item title, into a cache key
+func cacheKey(kind string, values ...string) string {
+	return strings.Join(append([]string{kind}, values...), "\x00")
+}
+
+// get returns the live entry for the key, if any
+func (cache *lookupCache) get(key string) (*cacheEntry, bool) {
+	if cache == nil {
+		return nil, false
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	element, exists := cache.entries[key]
+	if !exists {
+		return nil, false
+	}
+
+	entry := element.Value.(*cacheEntry)
+	if !cache.now().Before(entry.expires) {
+		cache.order.Remove(element)
+		delete(cache.entries, key)
+		return nil, false
+	}
+
+	return entry, true
+}
+
+// set stores a value or a not-found error, replacing any previous entry
+func (cache *lookupCache) set(key string, value interface{}, err error) {
+	if cache == nil {
+		return
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if element, exists := cache.entries[key]; exists {
+		cache.order.Remove(element)
+		delete(cache.entries, key)
+	}
+
+	for cache.order.Len() >= cache.maxEntries {
+		oldest := cache.order.Front()
+		cache.order.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*cacheEntry).key)
+	}
+
+	cache.entries[key] = cache.order.PushBack(&cacheEntry{
+		key:     key,
+		value:   value,
+		err:     err,
+		expires: cache.now().Add(cache.ttl),
+	})
+}
+
+// len returns the number of stored entries, including expired ones
+func (cache *lookupCache) len() int {
+	if cache == nil {
+		return 0
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	return cache.order.Len()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestCache(tb testing.TB, ttl time.Duration, maxEntries int) (*lookupCache, *time.Time) {
+	tb.Helper()
+
+	cache := newLookupCache(ttl, maxEntries)
+	if cache == nil {
+		tb.Fatal("newLookupCache() = nil, want a cache")
+	}
+
+	now := time.Date(2022, time.June, 1, 12, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time {
+		return now
+	}
+
+	return cache, &now
+}
+
+func Test_newLookupCache(t *testing.T) {
+	if cache := newLookupCache(0, 10); cache != nil {
+		t.Errorf("newLookupCache() = %v, want nil on a zero ttl", cache)
+	}
+
+	var cache *lookupCache
+	cache.set("foo", "bar", nil)
+	if _, hit := cache.get("foo"); hit {
+		t.Errorf("lookupCache.get() hit on a disabled cache")
+	}
+}
+
+func Test_lookupCache_expiry(t *testing.T) {
+	cache, now := newTestCache(t, time.Minute, 10)
+
+	cache.set("foo", "bar", nil)
+
+	*now = now.Add(59 * time.Second)
+	entry, hit := cache.get("foo")
+	if !hit || entry.value != "bar" {
+		t.Fatalf("lookupCache.get() = %v, %v, want bar before the ttl", entry, hit)
+	}
+
+	*now = now.Add(time.Second)
+	if _, hit := cache.get("foo"); hit {
+		t.Errorf("lookupCache.get() hit after the ttl")
+	}
+
+	if cache.len() != 0 {
+		t.Errorf("lookupCache.len() = %d, want the expired entry removed", cache.len())
+	}
+}
+
+func Test_lookupCache_eviction(t *testing.T) {
+	cache, _ := newTestCache(t, time.Minute, 2)
+
+	cache.set("a", 1, nil)
+	cache.set("b", 2, nil)
+	// replacing an entry makes it the newest
+	cache.set("a", 3, nil)
+	cache.set("c", 4, nil)
+
+	if cache.len() != 2 {
+		t.Errorf("lookupCache.len() = %d, want 2", cache.len())
+	}
+
+	if _, hit := cache.get("b"); hit {
+		t.Errorf("lookupCache.get() hit on the evicted oldest entry")
+	}
+
+	if entry, hit := cache.get("a"); !hit || entry.value != 3 {
+		t.Errorf("lookupCache.get() = %v, %v, want the replaced value", entry, hit)
+	}
+}
@@ -4,6 +4,10 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"strconv"
+	"time"
+
+	"github.com/wwmoraes/docker-engine-plugins/internal/common"
 )
 
 const (
@@ -13,12 +17,39 @@ const (
 	EnvToken string = `OP_CONNECT_TOKEN`
 	// EnvTokenFile is the token file environment variable name
 	EnvTokenFile string = `OP_CONNECT_TOKEN_FILE`
+	// EnvCacheTTL is the lookup cache entry lifetime environment variable name
+	EnvCacheTTL string = `CACHE_TTL`
+	// EnvCacheMaxEntries is the lookup cache size environment variable name
+	EnvCacheMaxEntries string = `CACHE_MAX_ENTRIES`
+	// EnvFileMaxSize is the file secret size limit environment variable name
+	EnvFileMaxSize string = `FILE_MAX_SIZE`
+	// EnvPolicy is the inline access policy environment variable name
+	EnvPolicy string = `POLICY`
+	// EnvPolicyPath is the access policy file environment variable name
+	EnvPolicyPath string = `POLICY_PATH`
+)
+
+const (
+	// DefaultCacheTTL is how long vault and item lookups are reused
+	DefaultCacheTTL time.Duration = time.Minute
+	// DefaultCacheMaxEntries is how many vault and item lookups are kept
+	DefaultCacheMaxEntries int = 1000
+	// DefaultFileMaxSize is the largest file returned as a secret, in bytes,
+	// which matches the Docker secret size limit
+	DefaultFileMaxSize int = 500 * 1024
 )
 
 // config contains all settings used by the main application
 type config struct {
 	URL   string
 	Token string
+	// CacheTTL is how long lookups are reused; zero disables the cache
+	CacheTTL        time.Duration
+	CacheMaxEntries int
+	// FileMaxSize limits the size of the files returned as secrets, in bytes
+	FileMaxSize int
+	// Policy restricts the secrets each service can read; nil allows all
+	Policy *policy
 }
 
 // newConfig loads settings from the environment
@@ -33,12 +64,65 @@ func newConfig() (*config, error) {
 		return nil, err
 	}
 
+	cacheTTL, err := time.ParseDuration(common.GetEnvDefault(EnvCacheTTL, DefaultCacheTTL.String()))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", EnvCacheTTL, err)
+	}
+
+	if cacheTTL < 0 {
+		return nil, fmt.Errorf("invalid %s: must not be negative", EnvCacheTTL)
+	}
+
+	cacheMaxEntries, err := strconv.Atoi(common.GetEnvDefault(EnvCacheMaxEntries, strconv.Itoa(DefaultCacheMaxEntries)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", EnvCacheMaxEntries, err)
+	}
+
+	if cacheMaxEntries <= 0 {
+		return nil, fmt.Errorf("invalid %s: must be positive", EnvCacheMaxEntries)
+	}
+
+	fileMaxSize, err := strconv.Atoi(common.GetEnvDefault(EnvFileMaxSize, strconv.Itoa(DefaultFileMaxSize)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", EnvFileMaxSize, err)
+	}
+
+	if fileMaxSize <= 0 {
+		return nil, fmt.Errorf("invalid %s: must be positive", EnvFileMaxSize)
+	}
+
+	policy, err := getPolicy()
+	if err != nil {
+		return nil, err
+	}
+
 	return &config{
-		URL:   host,
-		Token: token,
+		URL:             host,
+		Token:           token,
+		CacheTTL:        cacheTTL,
+		CacheMaxEntries: cacheMaxEntries,
+		FileMaxSize:     fileMaxSize,
+		Policy:          policy,
 	}, nil
 }
 
+// getPolicy loads the access policy set either inline or as a file, if any
+func getPolicy() (*policy, error) {
+	inline := os.Getenv(EnvPolicy)
+	policyPath := os.Getenv(EnvPolicyPath)
+
+	switch {
+	case inline != "" && policyPath != "":
+		return nil, fmt.Errorf("set either %s or %s, not both", EnvPolicy, EnvPolicyPath)
+	case inline != "":
+		return parsePolicy([]byte(inline))
+	case policyPath != "":
+		return loadPolicy(policyPath)
+	default:
+		return nil, nil
+	}
+}
+
 func getHost() (string, error) {
 	host, exists := os.LookupEnv(EnvHost)
 	if !exists {
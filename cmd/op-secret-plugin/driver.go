@@ -1,13 +1,19 @@
 package main
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"os"
+	"net/http"
+	"regexp"
+	"time"
 
 	"github.com/1Password/connect-sdk-go/connect"
 	"github.com/1Password/connect-sdk-go/onepassword"
 	"github.com/docker/go-plugins-helpers/secrets"
+	"github.com/wwmoraes/docker-engine-plugins/internal/common"
 )
 
 var (
@@ -15,24 +21,81 @@ var (
 	ErrVaultNotFound = errors.New("vault not found")
 	// ErrAmbiguousVaultName represents a title vault response that yields multiple vaults
 	ErrAmbiguousVaultName = errors.New("ambiguous Vault name")
+	// ErrItemNotFound represents an item title response that yields zero objects
+	ErrItemNotFound = errors.New("item not found")
+	// ErrAmbiguousItemTitle represents an item title response that yields multiple items
+	ErrAmbiguousItemTitle = errors.New("ambiguous item title")
+	// ErrSectionNotFound represents a section label that matches no item section
+	ErrSectionNotFound = errors.New("section not found")
+	// ErrFieldNotFound represents a field label that matches no item field
+	ErrFieldNotFound = errors.New("field not found")
+	// ErrInvalidTemplate is returned when the template label doesn't parse
+	ErrInvalidTemplate = errors.New("invalid template")
+	// ErrEmptyValue represents a field without a value, on secrets that don't
+	// allow empty values
+	ErrEmptyValue = errors.New("empty field value")
+	// ErrAmbiguousField represents a field label that matches multiple item fields
+	ErrAmbiguousField = errors.New("ambiguous field label")
+	// ErrFileNotFound represents a file name that matches no item file
+	ErrFileNotFound = errors.New("file not found")
+	// ErrAmbiguousFile represents a file name that matches multiple item files
+	ErrAmbiguousFile = errors.New("ambiguous file name")
+	// ErrFileTooLarge is returned when a file exceeds the size limit
+	ErrFileTooLarge = errors.New("file too large")
+	// ErrInvalidEncoding is returned when a value cannot be decoded as labelled
+	ErrInvalidEncoding = errors.New("invalid encoding")
+	// ErrInvalidOTP is returned when a field doesn't hold a TOTP secret
+	ErrInvalidOTP = errors.New("invalid one-time password")
+	// ErrAccessDenied is returned when the policy doesn't let a service read a secret
+	ErrAccessDenied = errors.New("access denied by policy")
 	// ErrNilClient is returned when a new driver is created with a nil client
 	ErrNilClient = errors.New("no client provided")
+	// ErrNilLogger is returned when a new driver is created with a nil logger
+	ErrNilLogger = errors.New("no logger provided")
+	// ErrNilConfig is returned when a new driver is created with a nil config
+	ErrNilConfig = errors.New("no config provided")
 	// ErrLabelNotFound is returned when mandatory labels are not found
 	ErrLabelNotFound = errors.New("label not found")
+	// ErrLabelConflict is returned when labels that exclude each other are set
+	ErrLabelConflict = errors.New("conflicting labels")
+	// ErrInvalidLabelValue is returned when a label has an unknown value
+	ErrInvalidLabelValue = errors.New("invalid label value")
+	// ErrInvalidReference is returned when the reference label isn't an op:// URI
+	ErrInvalidReference = errors.New("invalid secret reference")
 )
 
 type onePasswordDriver struct {
 	client connect.Client
+	logger *common.Logger
+	cache  *lookupCache
+	policy *policy
+	// fileMaxSize limits the size of the files returned as secrets, in bytes
+	fileMaxSize int
+	// now tells the time one-time passwords are computed for
+	now func() time.Time
 }
 
 // New wraps a 1Password Connect client as a Docker Engine secrets driver
-func New(client connect.Client) (secrets.Driver, error) {
+func New(client connect.Client, logger *common.Logger, config *config) (secrets.Driver, error) {
 	if client == nil {
 		return nil, ErrNilClient
 	}
 
+	if logger == nil {
+		return nil, ErrNilLogger
+	}
+
+	if config == nil {
+		return nil, ErrNilConfig
+	}
+
 	return &onePasswordDriver{
-		client: client,
+		client:      client,
+		logger:      logger,
+		cache:       newLookupCache(config.CacheTTL, config.CacheMaxEntries),
+		policy:      config.Policy,
+		fileMaxSize: config.FileMaxSize,
+		now:         time.Now,
 	}, nil
 }
 
@@ -52,34 +115,429 @@ func (driver *onePasswordDriver) getVaultByTitle(value string) (*onepassword.Vau
 	}
 }
 
+func (driver *onePasswordDriver) getItemByTitle(value string, vaultID string) (*onepassword.Item, error) {
+	items, err := driver.client.GetItemsByTitle(value, vaultID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(items) {
+	case 0:
+		return nil, ErrItemNotFound
+	case 1:
+		return driver.client.GetItem(items[0].ID, vaultID)
+	default:
+		return nil, ErrAmbiguousItemTitle
+	}
+}
+
+// uuidPattern matches the identifiers 1Password gives vaults and items
+var uuidPattern = regexp.MustCompile(`^[a-z0-9]{26}$`)
+
+// isUUID reports if the value looks like a 1Password identifier
+func isUUID(value string) bool {
+	return uuidPattern.MatchString(value)
+}
+
+// isNotFound reports if a Connect lookup by ID failed as there is no such object
+func isNotFound(err error) bool {
+	var opErr *onepassword.Error
+	if !errors.As(err, &opErr) {
+		return false
+	}
+
+	return opErr.StatusCode == http.StatusNotFound || opErr.StatusCode == http.StatusBadRequest
+}
+
+func (driver *onePasswordDriver) getVaultByID(value string) (*onepassword.Vault, error) {
+	vault, err := driver.client.GetVault(value)
+	if isNotFound(err) {
+		return nil, ErrVaultNotFound
+	}
+
+	return vault, err
+}
+
+func (driver *onePasswordDriver) getItemByID(value string, vaultID string) (*onepassword.Item, error) {
+	item, err := driver.client.GetItem(value, vaultID)
+	if isNotFound(err) {
+		return nil, ErrItemNotFound
+	}
+
+	return item, err
+}
+
+// cachedLookup returns the cached result for the key, or runs the lookup and
+// caches its value or not-found error. The cache is not read when cached is
+// false, and other errors are never cached
+func (driver *onePasswordDriver) cachedLookup(logger *common.Logger, key string, cached bool, notFound error, lookup func() (interface{}, error)) (interface{}, error) {
+	if cached {
+		if entry, hit := driver.cache.get(key); hit {
+			logger.Debug("cache hit")
+			return entry.value, entry.err
+		}
+
+		logger.Debug("cache miss")
+	}
+
+	value, err := lookup()
+	switch {
+	case errors.Is(err, notFound):
+		driver.cache.set(key, nil, err)
+		return nil, err
+	case err != nil:
+		return nil, err
+	}
+
+	driver.cache.set(key, value, nil)
+
+	return value, nil
+}
+
+// getVault resolves the vault labels. An explicit ID label is checked
+// directly, and a vault label that looks like an ID is tried as one before
+// falling back to a title lookup
+func (driver *onePasswordDriver) getVault(logger *common.Logger, values *labels, cached bool) (*onepassword.Vault, error) {
+	if values.VaultID != "" {
+		key := cacheKey("vault-id", values.VaultID)
+		value, err := driver.cachedLookup(logger.With("lookup", "vault-id"), key, cached, ErrVaultNotFound, func() (interface{}, error) {
+			return driver.getVaultByID(values.VaultID)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return value.(*onepassword.Vault), nil
+	}
+
+	key := cacheKey("vault", values.Vault)
+	value, err := driver.cachedLookup(logger.With("lookup", "vault"), key, cached, ErrVaultNotFound, func() (interface{}, error) {
+		if isUUID(values.Vault) {
+			vault, err := driver.getVaultByID(values.Vault)
+			if err == nil {
+				return vault, nil
+			}
+
+			if !errors.Is(err, ErrVaultNotFound) {
+				return nil, err
+			}
+		}
+
+		return driver.getVaultByTitle(values.Vault)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return value.(*onepassword.Vault), nil
+}
+
+// getItem resolves the item labels within the vault. An explicit ID label is
+// fetched directly, and an item label that looks like an ID is tried as one
+// before falling back to a title lookup
+func (driver *onePasswordDriver) getItem(logger *common.Logger, values *labels, vaultID string, cached bool) (*onepassword.Item, error) {
+	if values.ItemID != "" {
+		key := cacheKey("item-id", vaultID, values.ItemID)
+		value, err := driver.cachedLookup(logger.With("lookup", "item-id"), key, cached, ErrItemNotFound, func() (interface{}, error) {
+			return driver.getItemByID(values.ItemID, vaultID)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return value.(*onepassword.Item), nil
+	}
+
+	key := cacheKey("item", vaultID, values.Item)
+	value, err := driver.cachedLookup(logger.With("lookup", "item"), key, cached, ErrItemNotFound, func() (interface{}, error) {
+		if isUUID(values.Item) {
+			item, err := driver.getItemByID(values.Item, vaultID)
+			if err == nil {
+				return item, nil
+			}
+
+			if !errors.Is(err, ErrItemNotFound) {
+				return nil, err
+			}
+		}
+
+		return driver.getItemByTitle(values.Item, vaultID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return value.(*onepassword.Item), nil
+}
+
+// secretLookup holds the request state shared by the field reads of a secret
+type secretLookup struct {
+	logger   *common.Logger
+	req      secrets.Request
+	values   *labels
+	vault    *onepassword.Vault
+	reusable bool
+}
+
+// secretValue returns the selected field value, or the rendered template
+func (driver *onePasswordDriver) secretValue(lookup *secretLookup, item *onepassword.Item) (string, error) {
+	values := lookup.values
+
+	switch {
+	case values.Template != "":
+		return driver.renderTemplate(lookup, item, values.Template)
+	case values.File != "":
+		return driver.readFile(lookup, item, values.Section, values.File)
+	case values.TOTP:
+		return driver.readTOTP(lookup, item, values.Section, values.Field)
+	case values.TemplateField != "":
+		text, err := driver.readField(lookup, item, values.Section, values.TemplateField)
+		if err != nil {
+			return "", err
+		}
+
+		return driver.renderTemplate(lookup, item, text)
+	default:
+		return driver.readField(lookup, item, values.Section, values.Field)
+	}
+}
+
+// readField returns an item field value, if the policy lets the requesting
+// service read it
+func (driver *onePasswordDriver) readField(lookup *secretLookup, item *onepassword.Item, section string, name string) (string, error) {
+	field, err := findField(item, section, name)
+	if err != nil {
+		return "", err
+	}
+
+	err = driver.authorize(lookup, item, field.Label, field.ID)
+	if err != nil {
+		return "", err
+	}
+
+	return field.Value, nil
+}
+
+// readTOTP computes the current one-time password of an item field, or of
+// its OTP field if no name is given
+func (driver *onePasswordDriver) readTOTP(lookup *secretLookup, item *onepassword.Item, section string, name string) (string, error) {
+	var value string
+	if name != "" {
+		var err error
+		value, err = driver.readField(lookup, item, section, name)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		field, err := findOTPField(item, section)
+		if err != nil {
+			return "", err
+		}
+
+		err = driver.authorize(lookup, item, field.Label, field.ID)
+		if err != nil {
+			return "", err
+		}
+
+		value = field.Value
+	}
+
+	key, err := parseOTPKey(value)
+	if err != nil {
+		return "", err
+	}
+
+	return key.code(driver.now()), nil
+}
+
+// readFile downloads an item file, if the policy lets the requesting service
+// read it and it fits the size limit
+func (driver *onePasswordDriver) readFile(lookup *secretLookup, item *onepassword.Item, section string, name string) (string, error) {
+	file, err := findFile(item, section, name)
+	if err != nil {
+		return "", err
+	}
+
+	err = driver.authorize(lookup, item, file.Name, file.ID)
+	if err != nil {
+		return "", err
+	}
+
+	if file.Size > driver.fileMaxSize {
+		return "", fmt.Errorf("%w: %d bytes, over the %d bytes limit", ErrFileTooLarge, file.Size, driver.fileMaxSize)
+	}
+
+	// the client keeps the content on the file, which may be cached with the item
+	download := *file
+	content, err := driver.client.GetFileContent(&download)
+	if err != nil {
+		return "", err
+	}
+
+	if len(content) > driver.fileMaxSize {
+		return "", fmt.Errorf("%w: %d bytes, over the %d bytes limit", ErrFileTooLarge, len(content), driver.fileMaxSize)
+	}
+
+	return string(content), nil
+}
+
+// authorize checks if the policy lets the requesting service read an item
+// field or file, known by any of the names. It matches the resolved vault and
+// item, never the label values
+func (driver *onePasswordDriver) authorize(lookup *secretLookup, item *onepassword.Item, names ...string) error {
+	rule, allowed := driver.policy.allows(lookup.req, secretTarget{
+		vaults: []string{lookup.vault.Name, lookup.vault.ID},
+		items:  []string{item.Title, item.ID},
+		fields: names,
+	})
+	if !allowed {
+		return accessDenied(lookup.req)
+	}
+
+	if driver.policy != nil {
+		lookup.logger.Debug("access allowed", "rule", rule, "read_item", item.Title, "read_field", names[0])
+	}
+
+	return nil
+}
+
+// accessDenied describes a request the policy does not allow
+func accessDenied(req secrets.Request) error {
+	return fmt.Errorf("%w: service %q may not read secret %q", ErrAccessDenied, req.ServiceName, req.SecretName)
+}
+
+// transformValue trims the value, encodes it and appends a newline, as the
+// labels ask
+func transformValue(value []byte, values *labels) ([]byte, error) {
+	switch values.Trim {
+	case TrimNewline:
+		value = bytes.TrimRight(value, "\r\n")
+	case TrimSpace:
+		value = bytes.TrimSpace(value)
+	}
+
+	switch values.Encoding {
+	case EncodingBase64:
+		encoded := make([]byte, base64.StdEncoding.EncodedLen(len(value)))
+		base64.StdEncoding.Encode(encoded, value)
+		value = encoded
+	case EncodingBase64Decode:
+		decoded := make([]byte, base64.StdEncoding.DecodedLen(len(value)))
+		size, err := base64.StdEncoding.Decode(decoded, value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidEncoding, err)
+		}
+		value = decoded[:size]
+	case EncodingHex:
+		encoded := make([]byte, hex.EncodedLen(len(value)))
+		hex.Encode(encoded, value)
+		value = encoded
+	}
+
+	if values.Newline {
+		value = append(value, '\n')
+	}
+
+	return value, nil
+}
+
 // Get retrieves a secret value from 1Password
 func (driver *onePasswordDriver) Get(req secrets.Request) secrets.Response {
+	logger := driver.logger.With(
+		"request", "get",
+		"secret_name", req.SecretName,
+		"service", req.ServiceName,
+		"service_id", req.ServiceID,
+	)
+
 	values, err := newLabels(req.SecretLabels)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, fmt.Errorf("driver: %w", err))
+		logger.Error("invalid secret labels", "error", err)
 		return secrets.Response{
 			Err: err.Error(),
 		}
 	}
 
-	vault, err := driver.getVaultByTitle(values.Vault)
+	logger = logger.With(
+		"vault", values.Vault,
+		"vault_id", values.VaultID,
+		"item", values.Item,
+		"item_id", values.ItemID,
+		"section", values.Section,
+		"field", values.Field,
+		"template_field", values.TemplateField,
+		"file", values.File,
+	)
+
+	// services no rule matches are denied before any lookup, so they cannot
+	// tell missing vaults and items apart
+	if !driver.policy.allowsService(req) {
+		err = accessDenied(req)
+		logger.Warn("access denied", "task", req.TaskName, "error", err)
+		return secrets.Response{
+			Err: err.Error(),
+		}
+	}
+
+	// single-use secrets always get fresh values, and refresh the cache
+	reusable := values.Reusable == nil || *values.Reusable
+
+	vault, err := driver.getVault(logger, values, reusable)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, fmt.Errorf("driver: %w", err))
+		logger.Error("failed to get vault", "error", err)
 		return secrets.Response{
 			Err: err.Error(),
 		}
 	}
 
-	item, err := driver.client.GetItemByTitle(values.Item, vault.ID)
+	item, err := driver.getItem(logger, values, vault.ID, reusable)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, fmt.Errorf("driver: %w", err))
+		logger.Error("failed to get item", "error", err)
 		return secrets.Response{
 			Err: err.Error(),
 		}
 	}
 
+	value, err := driver.secretValue(&secretLookup{
+		logger:   logger,
+		req:      req,
+		values:   values,
+		vault:    vault,
+		reusable: reusable,
+	}, item)
+	if errors.Is(err, ErrAccessDenied) {
+		logger.Warn("access denied", "task", req.TaskName, "error", err)
+		return secrets.Response{
+			Err: err.Error(),
+		}
+	}
+
+	if err != nil {
+		logger.Error("failed to get value", "error", err)
+		return secrets.Response{
+			Err: err.Error(),
+		}
+	}
+
+	if value == "" && !values.AllowEmpty {
+		logger.Error("refused empty value", "error", ErrEmptyValue)
+		return secrets.Response{
+			Err: ErrEmptyValue.Error(),
+		}
+	}
+
+	output, err := transformValue([]byte(value), values)
+	if err != nil {
+		logger.Error("failed to transform value", "error", err)
+		return secrets.Response{
+			Err: err.Error(),
+		}
+	}
+
+	logger.Debug("secret retrieved")
+
 	return secrets.Response{
-		Value:      []byte(item.GetValue(values.Field)),
-		DoNotReuse: values.Reusable != nil && !*values.Reusable,
+		Value:      output,
+		DoNotReuse: !reusable || values.TOTP,
 	}
 }
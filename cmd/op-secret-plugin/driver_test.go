@@ -1,22 +1,29 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"reflect"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/1Password/connect-sdk-go/connect"
 	"github.com/1Password/connect-sdk-go/onepassword"
 	"github.com/docker/go-plugins-helpers/secrets"
 	"github.com/mitchellh/mapstructure"
+	"github.com/wwmoraes/docker-engine-plugins/internal/common"
 )
 
 func TestNew(t *testing.T) {
 	emptyClient := connect.NewClient("http://localhost", "")
+	logger := common.DiscardLogger()
 
 	type args struct {
 		client connect.Client
+		logger *common.Logger
+		config *config
 	}
 	tests := []struct {
 		name    string
@@ -28,9 +35,12 @@ func TestNew(t *testing.T) {
 			name: "valid client",
 			args: args{
 				client: emptyClient,
+				logger: logger,
+				config: &config{},
 			},
 			want: &onePasswordDriver{
 				client: emptyClient,
+				logger: logger,
 			},
 			wantErr: false,
 		},
@@ -38,6 +48,28 @@ func TestNew(t *testing.T) {
 			name: "nil client",
 			args: args{
 				client: nil,
+				logger: logger,
+				config: &config{},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "nil logger",
+			args: args{
+				client: emptyClient,
+				logger: nil,
+				config: &config{},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "nil config",
+			args: args{
+				client: emptyClient,
+				logger: logger,
+				config: nil,
 			},
 			want:    nil,
 			wantErr: true,
@@ -45,11 +77,18 @@ func TestNew(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := New(tt.args.client)
+			got, err := New(tt.args.client, tt.args.logger, tt.args.config)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			// functions are only deeply equal when nil
+			if driver, ok := got.(*onePasswordDriver); ok {
+				if driver.now == nil {
+					t.Errorf("New() has no clock")
+				}
+				driver.now = nil
+			}
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("New() = %v, want %v", got, tt.want)
 			}
@@ -154,7 +193,7 @@ func TestOnePasswordDriver_Get(t *testing.T) {
 			},
 			want: secrets.Response{
 				DoNotReuse: false,
-				Err:        fmt.Errorf("Found %d item(s) in vault %q with title %q", 0, mockVaultUUID, mockItemTitleNonExistent).Error(),
+				Err:        ErrItemNotFound.Error(),
 				Value:      nil,
 			},
 		},
@@ -228,6 +267,922 @@ func TestOnePasswordDriver_Get(t *testing.T) {
 	}
 }
 
+func TestOnePasswordDriver_Get_byID(t *testing.T) {
+	backend := newBackend(t, mockToken)
+	defer backend.Close()
+	client := newClient(t, backend)
+	driver := newDriver(t, client)
+
+	mockUUIDNonExistent := "abcdefghijklmnopqrstuvwxyz"
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   secrets.Response
+	}{
+		{
+			name: "detected vault UUID",
+			labels: map[string]string{
+				LabelVault: mockVaultUUID,
+				LabelItem:  mockItemTitle,
+				LabelField: mockItemFieldLabel,
+			},
+			want: secrets.Response{
+				Value: []byte(mockItemFieldValue),
+			},
+		},
+		{
+			name: "detected item UUID",
+			labels: map[string]string{
+				LabelVault: mockVaultTitle,
+				LabelItem:  mockItemUUID,
+				LabelField: mockItemFieldLabel,
+			},
+			want: secrets.Response{
+				Value: []byte(mockItemFieldValue),
+			},
+		},
+		{
+			name: "explicit ID labels",
+			labels: map[string]string{
+				LabelVaultID: mockVaultUUID,
+				LabelItemID:  mockItemUUID,
+				LabelField:   mockItemFieldLabel,
+			},
+			want: secrets.Response{
+				Value: []byte(mockItemFieldValue),
+			},
+		},
+		{
+			name: "non-existent vault ID",
+			labels: map[string]string{
+				LabelVaultID: mockUUIDNonExistent,
+				LabelItemID:  mockItemUUID,
+				LabelField:   mockItemFieldLabel,
+			},
+			want: secrets.Response{
+				Err: ErrVaultNotFound.Error(),
+			},
+		},
+		{
+			name: "non-existent item ID",
+			labels: map[string]string{
+				LabelVaultID: mockVaultUUID,
+				LabelItemID:  mockUUIDNonExistent,
+				LabelField:   mockItemFieldLabel,
+			},
+			want: secrets.Response{
+				Err: ErrItemNotFound.Error(),
+			},
+		},
+		{
+			name: "UUID-like vault title fallback",
+			labels: map[string]string{
+				LabelVault: mockUUIDNonExistent,
+				LabelItem:  mockItemTitle,
+				LabelField: mockItemFieldLabel,
+			},
+			want: secrets.Response{
+				Err: ErrVaultNotFound.Error(),
+			},
+		},
+		{
+			name: "dupe vault title",
+			labels: map[string]string{
+				LabelVault:  mockVaultDupeTitle,
+				LabelItemID: mockItemUUID,
+				LabelField:  mockItemFieldLabel,
+			},
+			want: secrets.Response{
+				Err: ErrAmbiguousVaultName.Error(),
+			},
+		},
+		{
+			name: "vault title and ID",
+			labels: map[string]string{
+				LabelVault:   mockVaultTitle,
+				LabelVaultID: mockVaultUUID,
+				LabelItem:    mockItemTitle,
+				LabelField:   mockItemFieldLabel,
+			},
+			want: secrets.Response{
+				Err: fmt.Errorf("%s and %s: %w", LabelVault, LabelVaultID, ErrLabelConflict).Error(),
+			},
+		},
+		{
+			name: "item title and ID",
+			labels: map[string]string{
+				LabelVault:  mockVaultTitle,
+				LabelItem:   mockItemTitle,
+				LabelItemID: mockItemUUID,
+				LabelField:  mockItemFieldLabel,
+			},
+			want: secrets.Response{
+				Err: fmt.Errorf("%s and %s: %w", LabelItem, LabelItemID, ErrLabelConflict).Error(),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := driver.Get(secrets.Request{SecretLabels: tt.labels})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("OnePasswordDriver.Get() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOnePasswordDriver_Get_ref(t *testing.T) {
+	backend := newBackend(t, mockToken)
+	defer backend.Close()
+	client := newClient(t, backend)
+	driver := newDriver(t, client)
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   secrets.Response
+	}{
+		{
+			name: "field",
+			labels: map[string]string{
+				LabelRef: "op://Test/Item/Field",
+			},
+			want: secrets.Response{
+				Value: []byte(mockItemFieldValue),
+			},
+		},
+		{
+			name: "section field",
+			labels: map[string]string{
+				LabelRef: "op://Test/Item/Database/Host",
+			},
+			want: secrets.Response{
+				Value: []byte(mockItemSectionFieldValue),
+			},
+		},
+		{
+			name: "percent-encoded",
+			labels: map[string]string{
+				LabelRef: "op://%54est/It%65m/Field",
+			},
+			want: secrets.Response{
+				Value: []byte(mockItemFieldValue),
+			},
+		},
+		{
+			name: "vault ID",
+			labels: map[string]string{
+				LabelRef: "op://" + mockVaultUUID + "/" + mockItemUUID + "/Field",
+			},
+			want: secrets.Response{
+				Value: []byte(mockItemFieldValue),
+			},
+		},
+		{
+			name: "mixed with section label",
+			labels: map[string]string{
+				LabelRef:     "op://Test/Item/Field",
+				LabelSection: mockItemSectionLabel,
+			},
+			want: secrets.Response{
+				Err: fmt.Errorf("%s and %s: %w", LabelRef, LabelSection, ErrLabelConflict).Error(),
+			},
+		},
+		{
+			name: "mixed with discrete labels",
+			labels: map[string]string{
+				LabelRef:   "op://Test/Item/Field",
+				LabelField: mockItemFieldLabel,
+			},
+			want: secrets.Response{
+				Err: fmt.Errorf("%s and %s: %w", LabelRef, LabelField, ErrLabelConflict).Error(),
+			},
+		},
+		{
+			name: "wrong scheme",
+			labels: map[string]string{
+				LabelRef: "https://Test/Item/Field",
+			},
+			want: secrets.Response{
+				Err: fmt.Errorf("%s: %w: must start with op://", LabelRef, ErrInvalidReference).Error(),
+			},
+		},
+		{
+			name: "missing field",
+			labels: map[string]string{
+				LabelRef: "op://Test/Item",
+			},
+			want: secrets.Response{
+				Err: fmt.Errorf("%s: %w: must be op://vault/item/[section/]field", LabelRef, ErrInvalidReference).Error(),
+			},
+		},
+		{
+			name: "empty segment",
+			labels: map[string]string{
+				LabelRef: "op://Test//Field",
+			},
+			want: secrets.Response{
+				Err: fmt.Errorf("%s: %w: empty path segment", LabelRef, ErrInvalidReference).Error(),
+			},
+		},
+		{
+			name: "invalid escape",
+			labels: map[string]string{
+				LabelRef: "op://Test/Item/Fi%zzeld",
+			},
+			want: secrets.Response{
+				Err: fmt.Errorf("%s: %w: %s", LabelRef, ErrInvalidReference, `invalid URL escape "%zz"`).Error(),
+			},
+		},
+		{
+			name: "unsupported attribute",
+			labels: map[string]string{
+				LabelRef: "op://Test/Item/Field?attribute=type",
+			},
+			want: secrets.Response{
+				Err: fmt.Errorf("%s: %w: only the otp attribute is supported", LabelRef, ErrInvalidReference).Error(),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := driver.Get(secrets.Request{SecretLabels: tt.labels})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("OnePasswordDriver.Get() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOnePasswordDriver_Get_field(t *testing.T) {
+	backend := newBackend(t, mockToken)
+	defer backend.Close()
+	client := newClient(t, backend)
+	driver := newDriver(t, client)
+
+	tests := []struct {
+		name       string
+		section    string
+		field      string
+		allowEmpty string
+		want       secrets.Response
+	}{
+		{
+			name:  "field ID",
+			field: mockItemSectionFieldID,
+			want: secrets.Response{
+				Value: []byte(mockItemSectionFieldValue),
+			},
+		},
+		{
+			name:    "section label",
+			section: mockItemSectionLabel,
+			field:   mockItemSectionFieldLabel,
+			want: secrets.Response{
+				Value: []byte(mockItemSectionFieldValue),
+			},
+		},
+		{
+			name:    "section ID",
+			section: mockItemReplicaSectionID,
+			field:   mockItemSectionFieldLabel,
+			want: secrets.Response{
+				Value: []byte(mockItemReplicaFieldValue),
+			},
+		},
+		{
+			name:  "ambiguous label",
+			field: mockItemSectionFieldLabel,
+			want: secrets.Response{
+				Err: ErrAmbiguousField.Error(),
+			},
+		},
+		{
+			name:  "section and field name",
+			field: mockItemSectionLabel + "." + mockItemSectionFieldLabel,
+			want: secrets.Response{
+				Value: []byte(mockItemSectionFieldValue),
+			},
+		},
+		{
+			name:  "missing section and field name",
+			field: "non-existent." + mockItemSectionFieldLabel,
+			want: secrets.Response{
+				Err: ErrFieldNotFound.Error(),
+			},
+		},
+		{
+			name:  "missing field",
+			field: "non-existent",
+			want: secrets.Response{
+				Err: ErrFieldNotFound.Error(),
+			},
+		},
+		{
+			name:    "field outside the section",
+			section: mockItemSectionLabel,
+			field:   mockItemFieldLabel,
+			want: secrets.Response{
+				Err: ErrFieldNotFound.Error(),
+			},
+		},
+		{
+			name:  "empty value",
+			field: mockItemEmptyFieldLabel,
+			want: secrets.Response{
+				Err: ErrEmptyValue.Error(),
+			},
+		},
+		{
+			name:       "allowed empty value",
+			field:      mockItemEmptyFieldLabel,
+			allowEmpty: "true",
+			want: secrets.Response{
+				Value: []byte{},
+			},
+		},
+		{
+			name:       "refused empty value",
+			field:      mockItemEmptyFieldLabel,
+			allowEmpty: "false",
+			want: secrets.Response{
+				Err: ErrEmptyValue.Error(),
+			},
+		},
+		{
+			name:    "missing section",
+			section: "non-existent",
+			field:   mockItemSectionFieldLabel,
+			want: secrets.Response{
+				Err: ErrSectionNotFound.Error(),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			labels := map[string]string{
+				LabelVault: mockVaultTitle,
+				LabelItem:  mockItemTitle,
+				LabelField: tt.field,
+			}
+			if tt.section != "" {
+				labels[LabelSection] = tt.section
+			}
+			if tt.allowEmpty != "" {
+				labels[LabelAllowEmpty] = tt.allowEmpty
+			}
+
+			got := driver.Get(secrets.Request{SecretLabels: labels})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("OnePasswordDriver.Get() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOnePasswordDriver_Get_template(t *testing.T) {
+	backend := newBackend(t, mockToken)
+	defer backend.Close()
+	client := newClient(t, backend)
+	driver := newDriver(t, client)
+
+	tests := []struct {
+		name    string
+		labels  map[string]string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "template label",
+			labels: map[string]string{
+				LabelItem:     mockTemplateItemTitle,
+				LabelTemplate: `postgres://{{ field "username" }}:{{ field "password" }}@{{ field "host" }}`,
+			},
+			want: mockTemplateValue,
+		},
+		{
+			name: "template field",
+			labels: map[string]string{
+				LabelItem:          mockTemplateItemTitle,
+				LabelTemplateField: mockTemplateFieldLabel,
+			},
+			want: mockTemplateValue,
+		},
+		{
+			name: "section field",
+			labels: map[string]string{
+				LabelItem:     mockItemTitle,
+				LabelTemplate: `{{ field "Database" "Host" }}`,
+			},
+			want: mockItemSectionFieldValue,
+		},
+		{
+			name: "other item",
+			labels: map[string]string{
+				LabelItem:     mockTemplateItemTitle,
+				LabelTemplate: `{{ field "username" }}:{{ item "Item" "Field" }}`,
+			},
+			want: "admin:" + mockItemFieldValue,
+		},
+		{
+			name: "missing field",
+			labels: map[string]string{
+				LabelItem:     mockTemplateItemTitle,
+				LabelTemplate: `{{ field "port" }}`,
+			},
+			wantErr: ErrFieldNotFound.Error(),
+		},
+		{
+			name: "missing other item",
+			labels: map[string]string{
+				LabelItem:     mockTemplateItemTitle,
+				LabelTemplate: `{{ item "Redis" "password" }}`,
+			},
+			wantErr: fmt.Errorf("Redis: %w", ErrItemNotFound).Error(),
+		},
+		{
+			name: "invalid template",
+			labels: map[string]string{
+				LabelItem:     mockTemplateItemTitle,
+				LabelTemplate: `{{ field "username" `,
+			},
+			wantErr: ErrInvalidTemplate.Error(),
+		},
+		{
+			name: "empty output",
+			labels: map[string]string{
+				LabelItem:     mockTemplateItemTitle,
+				LabelTemplate: `{{/* nothing */}}`,
+			},
+			wantErr: ErrEmptyValue.Error(),
+		},
+		{
+			name: "template and field",
+			labels: map[string]string{
+				LabelItem:     mockTemplateItemTitle,
+				LabelField:    "username",
+				LabelTemplate: `{{ field "username" }}`,
+			},
+			wantErr: fmt.Errorf("%s and %s: %w", LabelField, LabelTemplate, ErrLabelConflict).Error(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.labels[LabelVault] = mockVaultTitle
+
+			got := driver.Get(secrets.Request{SecretLabels: tt.labels})
+			if tt.wantErr != "" {
+				if !strings.Contains(got.Err, tt.wantErr) {
+					t.Errorf("OnePasswordDriver.Get() error = %v, want %v", got.Err, tt.wantErr)
+				}
+				return
+			}
+
+			if got.Err != "" || string(got.Value) != tt.want {
+				t.Errorf("OnePasswordDriver.Get() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOnePasswordDriver_Get_file(t *testing.T) {
+	backend := newBackend(t, mockToken)
+	defer backend.Close()
+	client := newClient(t, backend)
+	driver := newDriver(t, client)
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   secrets.Response
+	}{
+		{
+			name: "file name",
+			labels: map[string]string{
+				LabelFile: mockFileName,
+			},
+			want: secrets.Response{
+				Value: []byte(mockFileContent),
+			},
+		},
+		{
+			name: "file ID",
+			labels: map[string]string{
+				LabelFile: mockFileUUID,
+			},
+			want: secrets.Response{
+				Value: []byte(mockFileContent),
+			},
+		},
+		{
+			name: "missing file",
+			labels: map[string]string{
+				LabelFile: "tls.crt",
+			},
+			want: secrets.Response{
+				Err: ErrFileNotFound.Error(),
+			},
+		},
+		{
+			name: "file over the size limit",
+			labels: map[string]string{
+				LabelFile: mockLargeFileName,
+			},
+			want: secrets.Response{
+				Err: fmt.Errorf("%w: %d bytes, over the %d bytes limit", ErrFileTooLarge, DefaultFileMaxSize+1, DefaultFileMaxSize).Error(),
+			},
+		},
+		{
+			name: "file and field",
+			labels: map[string]string{
+				LabelField: mockItemFieldLabel,
+				LabelFile:  mockFileName,
+			},
+			want: secrets.Response{
+				Err: fmt.Errorf("%s and %s: %w", LabelField, LabelFile, ErrLabelConflict).Error(),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.labels[LabelVault] = mockVaultTitle
+			tt.labels[LabelItem] = mockFileItemTitle
+
+			got := driver.Get(secrets.Request{SecretLabels: tt.labels})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("OnePasswordDriver.Get() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOnePasswordDriver_Get_logging(t *testing.T) {
+	backend := newBackend(t, mockToken)
+	defer backend.Close()
+	client := newClient(t, backend)
+
+	var out bytes.Buffer
+	logger, err := common.NewLogger(&out, common.LevelDebug, common.FormatLogfmt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	driver, err := New(client, logger, &config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := driver.Get(secrets.Request{
+		SecretName:  "db-password",
+		ServiceName: "db",
+		SecretLabels: map[string]string{
+			LabelVault: mockVaultTitle,
+			LabelItem:  mockItemTitle,
+			LabelField: mockItemFieldLabel,
+		},
+	})
+	if got.Err != "" {
+		t.Fatal(got.Err)
+	}
+
+	if !strings.Contains(out.String(), "secret_name=db-password") {
+		t.Errorf("OnePasswordDriver.Get() log = %q, want the secret name", out.String())
+	}
+
+	if strings.Contains(out.String(), mockItemFieldValue) {
+		t.Errorf("OnePasswordDriver.Get() log = %q, want no secret value", out.String())
+	}
+}
+
+func Test_transformValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		labels  labels
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "untouched",
+			value: "lorem ipsum\n",
+			want:  "lorem ipsum\n",
+		},
+		{
+			name:   "raw",
+			value:  "lorem ipsum\n",
+			labels: labels{Encoding: EncodingRaw},
+			want:   "lorem ipsum\n",
+		},
+		{
+			name:   "base64",
+			value:  "lorem ipsum",
+			labels: labels{Encoding: EncodingBase64},
+			want:   "bG9yZW0gaXBzdW0=",
+		},
+		{
+			name:   "base64-decode",
+			value:  "bG9yZW0gaXBzdW0=",
+			labels: labels{Encoding: EncodingBase64Decode},
+			want:   "lorem ipsum",
+		},
+		{
+			name:    "base64-decode invalid",
+			value:   "lorem ipsum",
+			labels:  labels{Encoding: EncodingBase64Decode},
+			wantErr: true,
+		},
+		{
+			name:   "hex",
+			value:  "lorem",
+			labels: labels{Encoding: EncodingHex},
+			want:   "6c6f72656d",
+		},
+		{
+			name:   "trim newline",
+			value:  " lorem ipsum \r\n\n",
+			labels: labels{Trim: TrimNewline},
+			want:   " lorem ipsum ",
+		},
+		{
+			name:   "trim space",
+			value:  "\t lorem ipsum \r\n",
+			labels: labels{Trim: TrimSpace},
+			want:   "lorem ipsum",
+		},
+		{
+			name:   "newline",
+			value:  "lorem ipsum",
+			labels: labels{Newline: true},
+			want:   "lorem ipsum\n",
+		},
+		{
+			name:   "trim before decoding",
+			value:  "bG9yZW0gaXBzdW0=\n",
+			labels: labels{Encoding: EncodingBase64Decode, Trim: TrimNewline},
+			want:   "lorem ipsum",
+		},
+		{
+			name:   "newline after encoding",
+			value:  "lorem\n",
+			labels: labels{Encoding: EncodingHex, Trim: TrimNewline, Newline: true},
+			want:   "6c6f72656d\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := transformValue([]byte(tt.value), &tt.labels)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("transformValue() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && string(got) != tt.want {
+				t.Errorf("transformValue() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOnePasswordDriver_Get_encoding(t *testing.T) {
+	backend := newBackend(t, mockToken)
+	defer backend.Close()
+	client := newClient(t, backend)
+	driver := newDriver(t, client)
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   secrets.Response
+	}{
+		{
+			name: "base64 with newline",
+			labels: map[string]string{
+				LabelEncoding: EncodingBase64,
+				LabelNewline:  "true",
+			},
+			want: secrets.Response{
+				Value: []byte("ZG9sb3Igc2l0IGFtZXQ=\n"),
+			},
+		},
+		{
+			name: "unknown encoding",
+			labels: map[string]string{
+				LabelEncoding: "rot13",
+			},
+			want: secrets.Response{
+				Err: fmt.Errorf("%s: %w %q, expected one of raw, base64, base64-decode, hex", LabelEncoding, ErrInvalidLabelValue, "rot13").Error(),
+			},
+		},
+		{
+			name: "unknown trim",
+			labels: map[string]string{
+				LabelTrim: "tabs",
+			},
+			want: secrets.Response{
+				Err: fmt.Errorf("%s: %w %q, expected one of newline, space", LabelTrim, ErrInvalidLabelValue, "tabs").Error(),
+			},
+		},
+		{
+			name: "invalid base64 value",
+			labels: map[string]string{
+				LabelEncoding: EncodingBase64Decode,
+			},
+			want: secrets.Response{
+				Err: fmt.Errorf("%w: %s", ErrInvalidEncoding, "illegal base64 data at input byte 5").Error(),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.labels[LabelVault] = mockVaultTitle
+			tt.labels[LabelItem] = mockItemTitle
+			tt.labels[LabelField] = mockItemFieldLabel
+
+			got := driver.Get(secrets.Request{SecretLabels: tt.labels})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("OnePasswordDriver.Get() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOnePasswordDriver_Get_totp(t *testing.T) {
+	backend := newBackend(t, mockToken)
+	defer backend.Close()
+	client := newClient(t, backend)
+	driver := newDriver(t, client)
+
+	driver.(*onePasswordDriver).now = func() time.Time {
+		return time.Unix(59, 0)
+	}
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   secrets.Response
+	}{
+		{
+			name: "OTP field",
+			labels: map[string]string{
+				LabelVault: mockVaultTitle,
+				LabelItem:  mockItemTitle,
+				LabelTOTP:  "true",
+			},
+			want: secrets.Response{
+				Value:      []byte("94287082"),
+				DoNotReuse: true,
+			},
+		},
+		{
+			name: "named field",
+			labels: map[string]string{
+				LabelVault: mockVaultTitle,
+				LabelItem:  mockItemTitle,
+				LabelField: mockItemOTPFieldLabel,
+				LabelTOTP:  "true",
+			},
+			want: secrets.Response{
+				Value:      []byte("94287082"),
+				DoNotReuse: true,
+			},
+		},
+		{
+			name: "reference attribute",
+			labels: map[string]string{
+				LabelRef: "op://Test/Item/one-time%20password?attribute=otp",
+			},
+			want: secrets.Response{
+				Value:      []byte("94287082"),
+				DoNotReuse: true,
+			},
+		},
+		{
+			name: "field without a secret",
+			labels: map[string]string{
+				LabelVault: mockVaultTitle,
+				LabelItem:  mockItemTitle,
+				LabelField: mockItemFieldLabel,
+				LabelTOTP:  "true",
+			},
+			want: secrets.Response{
+				Err: fmt.Errorf("%w: not an otpauth URI", ErrInvalidOTP).Error(),
+			},
+		},
+		{
+			name: "item without an OTP field",
+			labels: map[string]string{
+				LabelVault: mockVaultTitle,
+				LabelItem:  mockTemplateItemTitle,
+				LabelTOTP:  "true",
+			},
+			want: secrets.Response{
+				Err: ErrFieldNotFound.Error(),
+			},
+		},
+		{
+			name: "file",
+			labels: map[string]string{
+				LabelVault: mockVaultTitle,
+				LabelItem:  mockFileItemTitle,
+				LabelFile:  mockFileName,
+				LabelTOTP:  "true",
+			},
+			want: secrets.Response{
+				Err: fmt.Errorf("%s and %s: %w", LabelTOTP, LabelFile, ErrLabelConflict).Error(),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := driver.Get(secrets.Request{SecretLabels: tt.labels})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("OnePasswordDriver.Get() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOnePasswordDriver_Get_cache(t *testing.T) {
+	backend := newBackend(t, mockToken)
+	defer backend.Close()
+	client := newClient(t, backend)
+	driver := newDriver(t, client)
+
+	request := func(vault string, reusable string) secrets.Request {
+		labels := map[string]string{
+			LabelVault: vault,
+			LabelItem:  mockItemTitle,
+			LabelField: mockItemFieldLabel,
+		}
+		if reusable != "" {
+			labels[LabelReusable] = reusable
+		}
+
+		return secrets.Request{SecretLabels: labels}
+	}
+
+	tests := []struct {
+		name         string
+		req          secrets.Request
+		wantErr      string
+		wantRequests uint64
+	}{
+		{
+			name:         "first lookup",
+			req:          request(mockVaultTitle, ""),
+			wantRequests: 3,
+		},
+		{
+			name:         "cached lookup",
+			req:          request(mockVaultTitle, ""),
+			wantRequests: 0,
+		},
+		{
+			name:         "single-use secret",
+			req:          request(mockVaultTitle, "false"),
+			wantRequests: 3,
+		},
+		{
+			name:         "first missing vault",
+			req:          request("non-existent", ""),
+			wantErr:      ErrVaultNotFound.Error(),
+			wantRequests: 1,
+		},
+		{
+			name:         "cached missing vault",
+			req:          request("non-existent", ""),
+			wantErr:      ErrVaultNotFound.Error(),
+			wantRequests: 0,
+		},
+		{
+			name:         "uncached ambiguous vault",
+			req:          request(mockVaultDupeTitle, ""),
+			wantErr:      ErrAmbiguousVaultName.Error(),
+			wantRequests: 1,
+		},
+		{
+			name:         "repeated ambiguous vault",
+			req:          request(mockVaultDupeTitle, ""),
+			wantErr:      ErrAmbiguousVaultName.Error(),
+			wantRequests: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := backend.Requests()
+
+			got := driver.Get(tt.req)
+			if got.Err != tt.wantErr {
+				t.Errorf("OnePasswordDriver.Get() error = %v, want %v", got.Err, tt.wantErr)
+			}
+
+			if requests := backend.Requests() - before; requests != tt.wantRequests {
+				t.Errorf("OnePasswordDriver.Get() made %d API call(s), want %d", requests, tt.wantRequests)
+			}
+		})
+	}
+}
+
 func Test_onePasswordDriver_getVaultByTitle(t *testing.T) {
 	backend := newBackend(t, mockToken)
 	defer backend.Close()
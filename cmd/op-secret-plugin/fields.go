@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/1Password/connect-sdk-go/onepassword"
+)
+
+// findSections returns the IDs of the item sections with the ID or label
+func findSections(item *onepassword.Item, section string) (map[string]bool, error) {
+	sectionIDs := make(map[string]bool)
+	for _, candidate := range item.Sections {
+		if candidate.ID == section || candidate.Label == section {
+			sectionIDs[candidate.ID] = true
+		}
+	}
+
+	if len(sectionIDs) == 0 {
+		return nil, ErrSectionNotFound
+	}
+
+	return sectionIDs, nil
+}
+
+// findField looks up an item field by ID or label, optionally within a section
+// picked by ID or label. Field IDs take precedence over labels, and a label
+// that matches fields on multiple sections is ambiguous. Without a section, a
+// section.field name that matches no field is looked up as the Connect SDK does
+func findField(item *onepassword.Item, section string, field string) (*onepassword.ItemField, error) {
+	found, err := findSectionField(item, section, field)
+	if section != "" || !errors.Is(err, ErrFieldNotFound) {
+		return found, err
+	}
+
+	parts := strings.Split(field, ".")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, err
+	}
+
+	found, dottedErr := findSectionField(item, parts[0], parts[1])
+	if errors.Is(dottedErr, ErrSectionNotFound) {
+		return nil, err
+	}
+
+	return found, dottedErr
+}
+
+// findSectionField looks up an item field by ID or label, optionally within a
+// section picked by ID or label
+func findSectionField(item *onepassword.Item, section string, field string) (*onepassword.ItemField, error) {
+	fields := item.Fields
+
+	if section != "" {
+		sectionIDs, err := findSections(item, section)
+		if err != nil {
+			return nil, err
+		}
+
+		fields = make([]*onepassword.ItemField, 0, len(item.Fields))
+		for _, candidate := range item.Fields {
+			if candidate.Section != nil && sectionIDs[candidate.Section.ID] {
+				fields = append(fields, candidate)
+			}
+		}
+	}
+
+	for _, candidate := range fields {
+		if candidate.ID != "" && candidate.ID == field {
+			return candidate, nil
+		}
+	}
+
+	var found *onepassword.ItemField
+	for _, candidate := range fields {
+		if candidate.Label != field {
+			continue
+		}
+
+		if found != nil {
+			return nil, ErrAmbiguousField
+		}
+
+		found = candidate
+	}
+
+	if found == nil {
+		return nil, ErrFieldNotFound
+	}
+
+	return found, nil
+}
+
+// findFile looks up an item file by ID or name, optionally within a section
+// picked by ID or label. File IDs take precedence over names, and a name that
+// matches multiple files is ambiguous
+func findFile(item *onepassword.Item, section string, name string) (*onepassword.File, error) {
+	files := item.Files
+
+	if section != "" {
+		sectionIDs, err := findSections(item, section)
+		if err != nil {
+			return nil, err
+		}
+
+		files = make([]*onepassword.File, 0, len(item.Files))
+		for _, candidate := range item.Files {
+			if candidate.Section != nil && sectionIDs[candidate.Section.ID] {
+				files = append(files, candidate)
+			}
+		}
+	}
+
+	for _, candidate := range files {
+		if candidate.ID != "" && candidate.ID == name {
+			return candidate, nil
+		}
+	}
+
+	var found *onepassword.File
+	for _, candidate := range files {
+		if candidate.Name != name {
+			continue
+		}
+
+		if found != nil {
+			return nil, ErrAmbiguousFile
+		}
+
+		found = candidate
+	}
+
+	if found == nil {
+		return nil, ErrFileNotFound
+	}
+
+	return found, nil
+}
+
+// findOTPField looks up the one-time password field of an item, optionally
+// within a section picked by ID or label
+func findOTPField(item *onepassword.Item, section string) (*onepassword.ItemField, error) {
+	var sectionIDs map[string]bool
+	if section != "" {
+		var err error
+		sectionIDs, err = findSections(item, section)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var found *onepassword.ItemField
+	for _, candidate := range item.Fields {
+		if candidate.Type != fieldTypeOTP {
+			continue
+		}
+
+		if sectionIDs != nil && (candidate.Section == nil || !sectionIDs[candidate.Section.ID]) {
+			continue
+		}
+
+		if found != nil {
+			return nil, ErrAmbiguousField
+		}
+
+		found = candidate
+	}
+
+	if found == nil {
+		return nil, ErrFieldNotFound
+	}
+
+	return found, nil
+}
@@ -2,17 +2,54 @@ package main
 
 import (
 	"fmt"
+	"net/url"
+	"strings"
 
 	"github.com/mitchellh/mapstructure"
 )
 
 const (
-	// LabelVault is the secret label key that holds the vault name
+	// LabelVault is the secret label key that holds the vault name or ID
 	LabelVault string = `connect.1password.io/vault`
-	// LabelItem is the secret label key that holds the item ID
+	// LabelVaultID is the secret label key that holds the vault ID
+	LabelVaultID string = `connect.1password.io/vault-id`
+	// LabelItem is the secret label key that holds the item title or ID
 	LabelItem string = `connect.1password.io/item`
-	// LabelField is the secret label key that holds the field name
+	// LabelItemID is the secret label key that holds the item ID
+	LabelItemID string = `connect.1password.io/item-id`
+	// LabelSection is the optional secret label key that holds the section
+	// name or ID the field belongs to
+	LabelSection string = `connect.1password.io/section`
+	// LabelField is the secret label key that holds the field name or ID
 	LabelField string = `connect.1password.io/field`
+	// LabelTemplate is the secret label key that holds a template to render
+	// with the item fields, in place of the field label
+	LabelTemplate string = `connect.1password.io/template`
+	// LabelTemplateField is the secret label key that holds the name or ID of
+	// the item field with a template to render, in place of the field label
+	LabelTemplateField string = `connect.1password.io/template-field`
+	// LabelFile is the secret label key that holds the name or ID of the item
+	// file to return, in place of the field label
+	LabelFile string = `connect.1password.io/file`
+	// LabelTOTP is the optional secret label key that returns the current
+	// time-based one-time password of the field, or of the item OTP field if no
+	// field label is set. These secrets are never reused
+	LabelTOTP string = `connect.1password.io/totp`
+	// LabelEncoding is the optional secret label key that sets how the value is
+	// encoded, one of raw, base64, base64-decode or hex
+	LabelEncoding string = `connect.1password.io/encoding`
+	// LabelTrim is the optional secret label key that trims the value before
+	// encoding it, either its trailing newlines or its surrounding whitespace
+	LabelTrim string = `connect.1password.io/trim`
+	// LabelNewline is the optional secret label key that appends a newline to
+	// the encoded value
+	LabelNewline string = `connect.1password.io/newline`
+	// LabelAllowEmpty is the optional secret label key that accepts fields
+	// without a value
+	LabelAllowEmpty string = `connect.1password.io/allow-empty`
+	// LabelRef is the secret label key that holds an op://vault/item/[section/]field
+	// reference, in place of the vault, item and field labels
+	LabelRef string = `connect.1password.io/ref`
 	// LabelReusable is the optional secret label key that sets a secret as single-use
 	LabelReusable string = `connect.1password.io/reusable`
 )
@@ -20,29 +57,227 @@ const (
 // labels contains all secret labels known and used by the driver. It
 // includes both mandatory and optional keys
 type labels struct {
-	Vault    string `mapstructure:"connect.1password.io/vault"`
-	Item     string `mapstructure:"connect.1password.io/item"`
-	Field    string `mapstructure:"connect.1password.io/field"`
-	Reusable *bool  `mapstructure:"connect.1password.io/reusable,omitempty"`
+	Vault   string `mapstructure:"connect.1password.io/vault"`
+	VaultID string `mapstructure:"connect.1password.io/vault-id"`
+	Item    string `mapstructure:"connect.1password.io/item"`
+	ItemID  string `mapstructure:"connect.1password.io/item-id"`
+	Section string `mapstructure:"connect.1password.io/section"`
+	Field   string `mapstructure:"connect.1password.io/field"`
+	Ref     string `mapstructure:"connect.1password.io/ref"`
+	// Template and TemplateField select a template to render instead of a field
+	Template      string `mapstructure:"connect.1password.io/template"`
+	TemplateField string `mapstructure:"connect.1password.io/template-field"`
+	File          string `mapstructure:"connect.1password.io/file"`
+	Reusable      *bool  `mapstructure:"connect.1password.io/reusable,omitempty"`
+	// AllowEmpty returns fields without a value instead of failing
+	AllowEmpty bool   `mapstructure:"connect.1password.io/allow-empty"`
+	Encoding   string `mapstructure:"connect.1password.io/encoding"`
+	Trim       string `mapstructure:"connect.1password.io/trim"`
+	Newline    bool   `mapstructure:"connect.1password.io/newline"`
+	TOTP       bool   `mapstructure:"connect.1password.io/totp"`
 }
 
+const (
+	// EncodingRaw returns the value as stored
+	EncodingRaw string = "raw"
+	// EncodingBase64 returns the value encoded as standard base64
+	EncodingBase64 string = "base64"
+	// EncodingBase64Decode returns the bytes of a standard base64 value
+	EncodingBase64Decode string = "base64-decode"
+	// EncodingHex returns the value encoded as lowercase hexadecimal
+	EncodingHex string = "hex"
+)
+
+const (
+	// TrimNewline removes the trailing line breaks
+	TrimNewline string = "newline"
+	// TrimSpace removes the leading and trailing whitespace
+	TrimSpace string = "space"
+)
+
+// referenceScheme prefixes the secret references, as used by the 1Password CLI
+const referenceScheme = "op://"
+
 // newLabels unmarshal a labels map and validates if the mandatory keys are set
 func newLabels(values map[string]string) (*labels, error) {
 	var labels labels
 
 	err := mapstructure.WeakDecode(values, &labels)
 
-	if err == nil && labels.Vault == "" {
-		err = fmt.Errorf("%s: %w", LabelVault, ErrLabelNotFound)
+	if err == nil && labels.Ref != "" {
+		err = labels.setReference()
+	}
+
+	if err == nil {
+		err = oneOfLabels(map[string]string{
+			LabelVault:   labels.Vault,
+			LabelVaultID: labels.VaultID,
+		}, LabelVault, LabelVaultID)
+	}
+
+	if err == nil {
+		err = oneOfLabels(map[string]string{
+			LabelItem:   labels.Item,
+			LabelItemID: labels.ItemID,
+		}, LabelItem, LabelItemID)
+	}
+
+	if err == nil && labels.TOTP {
+		err = totpLabels(&labels)
+	}
+
+	// one-time passwords may use the item OTP field instead
+	if err == nil && !(labels.TOTP && labels.Field == "") {
+		err = oneOfLabels(map[string]string{
+			LabelField:         labels.Field,
+			LabelTemplate:      labels.Template,
+			LabelTemplateField: labels.TemplateField,
+			LabelFile:          labels.File,
+		}, LabelField, LabelTemplate, LabelTemplateField, LabelFile)
 	}
 
-	if err == nil && labels.Item == "" {
-		err = fmt.Errorf("%s: %w", LabelItem, ErrLabelNotFound)
+	if err == nil {
+		err = allowedLabel(LabelEncoding, labels.Encoding, EncodingRaw, EncodingBase64, EncodingBase64Decode, EncodingHex)
 	}
 
-	if err == nil && labels.Field == "" {
-		err = fmt.Errorf("%s: %w", LabelField, ErrLabelNotFound)
+	if err == nil {
+		err = allowedLabel(LabelTrim, labels.Trim, TrimNewline, TrimSpace)
 	}
 
 	return &labels, err
 }
+
+// totpLabels checks that one-time passwords are read from a field, rather
+// than a template or file
+func totpLabels(labels *labels) error {
+	selectors := map[string]string{
+		LabelTemplate:      labels.Template,
+		LabelTemplateField: labels.TemplateField,
+		LabelFile:          labels.File,
+	}
+	for _, key := range []string{LabelTemplate, LabelTemplateField, LabelFile} {
+		if selectors[key] != "" {
+			return fmt.Errorf("%s and %s: %w", LabelTOTP, key, ErrLabelConflict)
+		}
+	}
+
+	return nil
+}
+
+// allowedLabel checks that an optional label is either unset or one of the
+// allowed values
+func allowedLabel(key string, value string, allowed ...string) error {
+	if value == "" {
+		return nil
+	}
+
+	for _, candidate := range allowed {
+		if value == candidate {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s: %w %q, expected one of %s", key, ErrInvalidLabelValue, value, strings.Join(allowed, ", "))
+}
+
+// oneOfLabels checks that exactly one of the keys has a value. A missing value
+// is reported on the first key
+func oneOfLabels(values map[string]string, keys ...string) error {
+	set := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if values[key] != "" {
+			set = append(set, key)
+		}
+	}
+
+	switch len(set) {
+	case 0:
+		return fmt.Errorf("%s: %w", keys[0], ErrLabelNotFound)
+	case 1:
+		return nil
+	default:
+		return fmt.Errorf("%s and %s: %w", set[0], set[1], ErrLabelConflict)
+	}
+}
+
+// setReference parses the reference label into the vault, item, section and
+// field, which must not be set through their own labels
+func (labels *labels) setReference() error {
+	discrete := map[string]string{
+		LabelVault:         labels.Vault,
+		LabelVaultID:       labels.VaultID,
+		LabelItem:          labels.Item,
+		LabelItemID:        labels.ItemID,
+		LabelSection:       labels.Section,
+		LabelField:         labels.Field,
+		LabelTemplate:      labels.Template,
+		LabelTemplateField: labels.TemplateField,
+		LabelFile:          labels.File,
+	}
+	for _, key := range []string{LabelVault, LabelVaultID, LabelItem, LabelItemID, LabelSection, LabelField, LabelTemplate, LabelTemplateField, LabelFile} {
+		if discrete[key] != "" {
+			return fmt.Errorf("%s and %s: %w", LabelRef, key, ErrLabelConflict)
+		}
+	}
+
+	if !strings.HasPrefix(labels.Ref, referenceScheme) {
+		return fmt.Errorf("%s: %w: must start with %s", LabelRef, ErrInvalidReference, referenceScheme)
+	}
+
+	if strings.Contains(labels.Ref, "#") {
+		return fmt.Errorf("%s: %w: fragments are not supported", LabelRef, ErrInvalidReference)
+	}
+
+	ref, rawQuery, hasQuery := cutString(labels.Ref, "?")
+	if hasQuery {
+		query, err := url.ParseQuery(rawQuery)
+		if err != nil {
+			return fmt.Errorf("%s: %w: %s", LabelRef, ErrInvalidReference, err)
+		}
+
+		attribute := query.Get("attribute")
+		if len(query) != 1 || (attribute != "otp" && attribute != "totp") {
+			return fmt.Errorf("%s: %w: only the otp attribute is supported", LabelRef, ErrInvalidReference)
+		}
+
+		labels.TOTP = true
+	}
+
+	segments := strings.Split(strings.TrimPrefix(ref, referenceScheme), "/")
+	if len(segments) != 3 && len(segments) != 4 {
+		return fmt.Errorf("%s: %w: must be %svault/item/[section/]field", LabelRef, ErrInvalidReference, referenceScheme)
+	}
+
+	for index, segment := range segments {
+		value, err := url.PathUnescape(segment)
+		if err != nil {
+			return fmt.Errorf("%s: %w: %s", LabelRef, ErrInvalidReference, err)
+		}
+
+		if value == "" {
+			return fmt.Errorf("%s: %w: empty path segment", LabelRef, ErrInvalidReference)
+		}
+
+		segments[index] = value
+	}
+
+	labels.Vault = segments[0]
+	labels.Item = segments[1]
+	labels.Field = segments[len(segments)-1]
+	if len(segments) == 4 {
+		labels.Section = segments[2]
+	}
+
+	return nil
+}
+
+// cutString slices the value around the first separator, as strings.Cut does
+// on newer Go versions
+func cutString(value string, separator string) (string, string, bool) {
+	index := strings.Index(value, separator)
+	if index < 0 {
+		return value, "", false
+	}
+
+	return value[:index], value[index+len(separator):], true
+}
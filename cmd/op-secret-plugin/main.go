@@ -1,10 +1,14 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"net/http"
 	"os"
 
 	"github.com/1Password/connect-sdk-go/connect"
+	"github.com/1Password/connect-sdk-go/onepassword"
 	"github.com/docker/go-plugins-helpers/secrets"
 	"github.com/wwmoraes/docker-engine-plugins/internal/common"
 )
@@ -31,15 +35,54 @@ func readFileString(name string) (string, error) {
 	return string(fileBytes), nil
 }
 
+var check = flag.Bool("check", false, "validate the configuration and the 1Password Connect reachability, then exit")
+
 func main() {
+	flag.Parse()
+
+	logger, err := common.NewLoggerFromEnv(PluginName)
+	if err != nil {
+		os.Exit(common.StartupFailed(os.Stderr, &common.ConfigError{Err: err}))
+	}
+
 	config, err := newConfig()
-	common.Assert(err)
+	if err != nil {
+		os.Exit(common.StartupFailed(os.Stderr, &common.ConfigError{Err: err}))
+	}
 
 	client := connect.NewClient(config.URL, config.Token)
 
-	driver, err := New(client)
-	common.Assert(err)
+	if *check {
+		err = checkBackend(client)
+		if err != nil {
+			os.Exit(common.StartupFailed(os.Stderr, err))
+		}
+
+		logger.Info("configuration and 1Password Connect are valid")
+		return
+	}
+
+	driver, err := New(client, logger, config)
+	if err != nil {
+		os.Exit(common.StartupFailed(os.Stderr, err))
+	}
 
-	handler := secrets.NewHandler(driver)
-	common.Assert(handler.ServeUnix(PluginName, 0))
+	lifecycle := common.NewLifecycle(PluginName, 0, logger)
+	os.Exit(lifecycle.Serve(secrets.NewHandler(driver)))
+}
+
+// checkBackend lists the vaults to ensure the Connect server is reachable and
+// accepts the token
+func checkBackend(client connect.Client) error {
+	_, err := client.GetVaults()
+
+	var opErr *onepassword.Error
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &opErr) && (opErr.StatusCode == http.StatusUnauthorized || opErr.StatusCode == http.StatusForbidden):
+		return &common.PermissionError{Err: fmt.Errorf("1Password Connect rejected the token: %w", err)}
+	default:
+		return &common.BackendUnavailable{Backend: "1Password Connect", Err: err}
+	}
 }
@@ -16,9 +16,10 @@ import (
 	"testing"
 	"time"
 
+	"github.com/1Password/connect-sdk-go/connect"
 	"github.com/1Password/connect-sdk-go/onepassword"
 	sm "github.com/cch123/supermonkey"
-	_ "github.com/docker/go-plugins-helpers/sdk"
+	"github.com/wwmoraes/docker-engine-plugins/internal/common"
 )
 
 func tempFile(tb testing.TB, content string) *os.File {
@@ -60,7 +61,7 @@ type newUnixListenerFunc = func(pluginName string, gid int) (net.Listener, strin
 
 // genNewUnixListener replacement that doesn't try to chown the created socket,
 // so the current user can remove the socket
-// see github.com/docker/go-plugins-helpers/sdk.newUnixListener
+// see github.com/wwmoraes/docker-engine-plugins/internal/common.newUnixListener
 func genNewUnixListener(tb testing.TB, fullSocketAddress fullSocketAddressFunc) newUnixListenerFunc {
 	tb.Helper()
 
@@ -114,6 +115,21 @@ func Test_getConfig(t *testing.T) {
 	originalToken := os.Getenv(EnvToken)
 	defer os.Setenv(EnvToken, originalToken)
 
+	originalCacheTTL := os.Getenv(EnvCacheTTL)
+	defer os.Setenv(EnvCacheTTL, originalCacheTTL)
+
+	originalCacheMaxEntries := os.Getenv(EnvCacheMaxEntries)
+	defer os.Setenv(EnvCacheMaxEntries, originalCacheMaxEntries)
+
+	originalFileMaxSize := os.Getenv(EnvFileMaxSize)
+	defer os.Setenv(EnvFileMaxSize, originalFileMaxSize)
+
+	originalPolicy := os.Getenv(EnvPolicy)
+	defer os.Setenv(EnvPolicy, originalPolicy)
+
+	originalPolicyPath := os.Getenv(EnvPolicyPath)
+	defer os.Setenv(EnvPolicyPath, originalPolicyPath)
+
 	testHostA := fmt.Sprintf("%s-%d", mockHost, rand.Uint64())
 	testTokenA := fmt.Sprintf("%s-%d", mockToken, rand.Uint64())
 
@@ -149,8 +165,11 @@ func Test_getConfig(t *testing.T) {
 				{false, EnvTokenFile, ""},
 			},
 			want: &config{
-				URL:   testHostA,
-				Token: testTokenA,
+				URL:             testHostA,
+				Token:           testTokenA,
+				CacheTTL:        DefaultCacheTTL,
+				CacheMaxEntries: DefaultCacheMaxEntries,
+				FileMaxSize:     DefaultFileMaxSize,
 			},
 		},
 		{
@@ -161,8 +180,11 @@ func Test_getConfig(t *testing.T) {
 				{true, EnvTokenFile, testTokenFileB.Name()},
 			},
 			want: &config{
-				URL:   testHostB,
-				Token: testTokenB,
+				URL:             testHostB,
+				Token:           testTokenB,
+				CacheTTL:        DefaultCacheTTL,
+				CacheMaxEntries: DefaultCacheMaxEntries,
+				FileMaxSize:     DefaultFileMaxSize,
 			},
 		},
 		{
@@ -173,8 +195,11 @@ func Test_getConfig(t *testing.T) {
 				{true, EnvTokenFile, testTokenFileB.Name()},
 			},
 			want: &config{
-				URL:   testHostB,
-				Token: testTokenA,
+				URL:             testHostB,
+				Token:           testTokenA,
+				CacheTTL:        DefaultCacheTTL,
+				CacheMaxEntries: DefaultCacheMaxEntries,
+				FileMaxSize:     DefaultFileMaxSize,
 			},
 		},
 		{
@@ -267,6 +292,99 @@ func Test_getConfig(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "cache settings",
+			vars: []EnvVar{
+				{true, EnvHost, testHostA},
+				{true, EnvToken, testTokenA},
+				{true, EnvCacheTTL, "0s"},
+				{true, EnvCacheMaxEntries, "10"},
+			},
+			want: &config{
+				URL:             testHostA,
+				Token:           testTokenA,
+				CacheTTL:        0,
+				CacheMaxEntries: 10,
+				FileMaxSize:     DefaultFileMaxSize,
+			},
+		},
+		{
+			name: "invalid cache ttl",
+			vars: []EnvVar{
+				{true, EnvHost, testHostA},
+				{true, EnvToken, testTokenA},
+				{true, EnvCacheTTL, "soon"},
+				{false, EnvCacheMaxEntries, ""},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid cache max entries",
+			vars: []EnvVar{
+				{true, EnvHost, testHostA},
+				{true, EnvToken, testTokenA},
+				{false, EnvCacheTTL, ""},
+				{true, EnvCacheMaxEntries, "0"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid file max size",
+			vars: []EnvVar{
+				{true, EnvHost, testHostA},
+				{true, EnvToken, testTokenA},
+				{false, EnvCacheMaxEntries, ""},
+				{true, EnvFileMaxSize, "1MB"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid policy",
+			vars: []EnvVar{
+				{true, EnvHost, testHostA},
+				{true, EnvToken, testTokenA},
+				{false, EnvCacheMaxEntries, ""},
+				{false, EnvFileMaxSize, ""},
+				{true, EnvPolicyPath, testFileEmpty.Name()},
+			},
+			wantErr: true,
+		},
+		{
+			name: "inline and file policies",
+			vars: []EnvVar{
+				{true, EnvHost, testHostA},
+				{true, EnvToken, testTokenA},
+				{true, EnvPolicy, mockPolicy},
+				{true, EnvPolicyPath, tempFile(t, mockPolicy).Name()},
+			},
+			wantErr: true,
+		},
+		{
+			name: "inline policy",
+			vars: []EnvVar{
+				{true, EnvHost, testHostA},
+				{true, EnvToken, testTokenA},
+				{true, EnvPolicy, `{"rules": [{"service": "web", "vaults": ["*"], "items": ["*"], "fields": ["*"]}]}`},
+				{false, EnvPolicyPath, ""},
+			},
+			want: &config{
+				URL:             testHostA,
+				Token:           testTokenA,
+				CacheTTL:        DefaultCacheTTL,
+				CacheMaxEntries: DefaultCacheMaxEntries,
+				FileMaxSize:     DefaultFileMaxSize,
+				Policy: &policy{
+					Rules: []policyRule{
+						{
+							Service: "web",
+							Vaults:  []string{"*"},
+							Items:   []string{"*"},
+							Fields:  []string{"*"},
+						},
+					},
+				},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -299,7 +417,7 @@ func Test_main(t *testing.T) {
 
 	fullSocketAddress := genFullSocketAddress(socketDir)
 	newUnixListener := genNewUnixListener(t, fullSocketAddress)
-	patch := sm.PatchByFullSymbolName("github.com/docker/go-plugins-helpers/sdk.newUnixListener", newUnixListener)
+	patch := sm.PatchByFullSymbolName("github.com/wwmoraes/docker-engine-plugins/internal/common.newUnixListener", newUnixListener)
 	defer patch.Unpatch()
 
 	go main()
@@ -351,3 +469,41 @@ func Test_main(t *testing.T) {
 		t.Fatalf("invalid response, got %#+v, wanted %#+v", got, backend.vaults)
 	}
 }
+
+func Test_checkBackend(t *testing.T) {
+	backend := newBackend(t, mockToken)
+	defer backend.Close()
+
+	rejectingBackend := newBackend(t, "another."+mockToken)
+	defer rejectingBackend.Close()
+
+	tests := []struct {
+		name   string
+		client connect.Client
+		want   int
+	}{
+		{
+			name:   "reachable",
+			client: newClient(t, backend),
+			want:   common.ExitOK,
+		},
+		{
+			name:   "rejected token",
+			client: newClient(t, rejectingBackend),
+			want:   common.ExitPermissionError,
+		},
+		{
+			name:   "unreachable",
+			client: connect.NewClient("http://127.0.0.1:1", mockToken),
+			want:   common.ExitBackendUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkBackend(tt.client)
+			if got := common.ExitCode(err); got != tt.want {
+				t.Errorf("checkBackend() error = %v, exit code %d, want %d", err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/docker/go-plugins-helpers/secrets"
+)
+
+// policy restricts which services can read which secrets. A service may only
+// read a field if a rule matching the service allows its vault, item and field
+type policy struct {
+	Rules []policyRule `json:"rules"`
+}
+
+// policyRule maps the services matching all its selectors to the vaults, items
+// and fields they may read. Each value is a glob pattern
+type policyRule struct {
+	// Service matches the service name
+	Service string `json:"service,omitempty"`
+	// ServiceLabels match the service label values
+	ServiceLabels map[string]string `json:"serviceLabels,omitempty"`
+	// Vaults match the vault titles or IDs
+	Vaults []string `json:"vaults"`
+	// Items match the item titles or IDs
+	Items []string `json:"items"`
+	// Fields match the field labels or IDs
+	Fields []string `json:"fields"`
+}
+
+// secretTarget holds the names and IDs of a resolved secret, as matched by
+// the policy rules
+type secretTarget struct {
+	vaults []string
+	items  []string
+	fields []string
+}
+
+// loadPolicy reads and validates a JSON policy file
+func loadPolicy(name string) (*policy, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy: %w", err)
+	}
+
+	return parsePolicy(data)
+}
+
+// parsePolicy decodes and validates a JSON policy
+func parsePolicy(data []byte) (*policy, error) {
+	var policy policy
+	err := json.Unmarshal(data, &policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse policy: %w", err)
+	}
+
+	err = policy.validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy: %w", err)
+	}
+
+	return &policy, nil
+}
+
+func (policy *policy) validate() error {
+	for index, rule := range policy.Rules {
+		if rule.Service == "" && len(rule.ServiceLabels) == 0 {
+			return fmt.Errorf("rule %d: no service or service labels to match", index)
+		}
+
+		patterns := map[string][]string{
+			"vaults": rule.Vaults,
+			"items":  rule.Items,
+			"fields": rule.Fields,
+		}
+		for key, values := range patterns {
+			if len(values) == 0 {
+				return fmt.Errorf("rule %d: no %s allowed", index, key)
+			}
+
+			for _, value := range values {
+				_, err := path.Match(value, "")
+				if err != nil {
+					return fmt.Errorf("rule %d: %s pattern %q: %w", index, key, value, err)
+				}
+			}
+		}
+
+		for key, value := range rule.ServiceLabels {
+			_, err := path.Match(value, "")
+			if err != nil {
+				return fmt.Errorf("rule %d: service label %s pattern %q: %w", index, key, value, err)
+			}
+		}
+
+		_, err := path.Match(rule.Service, "")
+		if err != nil {
+			return fmt.Errorf("rule %d: service pattern %q: %w", index, rule.Service, err)
+		}
+	}
+
+	return nil
+}
+
+// allows reports if a rule lets the requesting service read the target, and
+// returns the index of the first one that does. A nil policy allows everything
+func (policy *policy) allows(req secrets.Request, target secretTarget) (int, bool) {
+	if policy == nil {
+		return -1, true
+	}
+
+	for index, rule := range policy.Rules {
+		if rule.matchesService(req) &&
+			matchesAny(rule.Vaults, target.vaults) &&
+			matchesAny(rule.Items, target.items) &&
+			matchesAny(rule.Fields, target.fields) {
+			return index, true
+		}
+	}
+
+	return -1, false
+}
+
+// allowsService reports if any rule matches the requesting service. A nil
+// policy allows every service
+func (policy *policy) allowsService(req secrets.Request) bool {
+	if policy == nil {
+		return true
+	}
+
+	for _, rule := range policy.Rules {
+		if rule.matchesService(req) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (rule *policyRule) matchesService(req secrets.Request) bool {
+	if rule.Service != "" && !matchesAny([]string{rule.Service}, []string{req.ServiceName}) {
+		return false
+	}
+
+	for key, pattern := range rule.ServiceLabels {
+		value, exists := req.ServiceLabels[key]
+		if !exists || !matchesAny([]string{pattern}, []string{value}) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchesAny reports if any of the non-empty values matches any of the
+// patterns. Patterns were validated on load, so match errors are not possible
+func matchesAny(patterns []string, values []string) bool {
+	for _, value := range values {
+		if value == "" {
+			continue
+		}
+
+		for _, pattern := range patterns {
+			if matchGlob(pattern, value) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// globSeparator replaces the slashes before matching, as path.Match doesn't
+// match them with wildcards, while names such as db/prod are common titles
+const globSeparator = "\x00"
+
+// matchGlob reports if the value matches the glob pattern, with wildcards also
+// matching slashes
+func matchGlob(pattern string, value string) bool {
+	matched, _ := path.Match(
+		strings.ReplaceAll(pattern, "/", globSeparator),
+		strings.ReplaceAll(value, "/", globSeparator),
+	)
+
+	return matched
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/1Password/connect-sdk-go/connect"
+	"github.com/docker/go-plugins-helpers/secrets"
+	"github.com/wwmoraes/docker-engine-plugins/internal/common"
+)
+
+const mockPolicy string = `{
+  "rules": [
+    {
+      "service": "web-*",
+      "vaults": ["Test"],
+      "items": ["Item"],
+      "fields": ["Field"]
+    },
+    {
+      "serviceLabels": {"com.example.team": "data*"},
+      "vaults": ["*"],
+      "items": ["*"],
+      "fields": ["*"]
+    }
+  ]
+}`
+
+func Test_loadPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{
+			name:    "valid",
+			content: mockPolicy,
+		},
+		{
+			name:    "invalid JSON",
+			content: `{"rules": [`,
+			wantErr: true,
+		},
+		{
+			name:    "no service selector",
+			content: `{"rules": [{"vaults": ["*"], "items": ["*"], "fields": ["*"]}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "no fields",
+			content: `{"rules": [{"service": "*", "vaults": ["*"], "items": ["*"]}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid pattern",
+			content: `{"rules": [{"service": "[", "vaults": ["*"], "items": ["*"], "fields": ["*"]}]}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadPolicy(tempFile(t, tt.content).Name())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("loadPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_matchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		value   string
+		want    bool
+	}{
+		{"*", "db/prod", true},
+		{"db/*", "db/prod", true},
+		{"db?prod", "db/prod", true},
+		{"*/prod", "db/staging", false},
+		{"[^/]*", "/db", false},
+		{"web-*", "web-frontend", true},
+		{"web-*", "db", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.value, func(t *testing.T) {
+			if got := matchGlob(tt.pattern, tt.value); got != tt.want {
+				t.Errorf("matchGlob() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_policy_allows(t *testing.T) {
+	rules, err := loadPolicy(tempFile(t, mockPolicy).Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := secretTarget{
+		vaults: []string{mockVaultTitle, "", mockVaultUUID},
+		items:  []string{mockItemTitle, mockItemUUID},
+		fields: []string{mockItemFieldLabel, ""},
+	}
+
+	tests := []struct {
+		name     string
+		policy   *policy
+		req      secrets.Request
+		target   secretTarget
+		wantRule int
+		want     bool
+	}{
+		{
+			name:     "no policy",
+			req:      secrets.Request{ServiceName: "db"},
+			target:   target,
+			wantRule: -1,
+			want:     true,
+		},
+		{
+			name:     "service name",
+			policy:   rules,
+			req:      secrets.Request{ServiceName: "web-frontend"},
+			target:   target,
+			wantRule: 0,
+			want:     true,
+		},
+		{
+			name:   "service name with another field",
+			policy: rules,
+			req:    secrets.Request{ServiceName: "web-frontend"},
+			target: secretTarget{
+				vaults: target.vaults,
+				items:  target.items,
+				fields: []string{mockItemSectionFieldLabel},
+			},
+			wantRule: -1,
+			want:     false,
+		},
+		{
+			name:   "service label",
+			policy: rules,
+			req: secrets.Request{
+				ServiceName:   "db",
+				ServiceLabels: map[string]string{"com.example.team": "database"},
+			},
+			target:   target,
+			wantRule: 1,
+			want:     true,
+		},
+		{
+			name:     "unknown service",
+			policy:   rules,
+			req:      secrets.Request{ServiceName: "db"},
+			target:   target,
+			wantRule: -1,
+			want:     false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotRule, got := tt.policy.allows(tt.req, tt.target)
+			if got != tt.want || gotRule != tt.wantRule {
+				t.Errorf("policy.allows() = %d, %v, want %d, %v", gotRule, got, tt.wantRule, tt.want)
+			}
+		})
+	}
+}
+
+func TestOnePasswordDriver_Get_policy(t *testing.T) {
+	backend := newBackend(t, mockToken)
+	defer backend.Close()
+	client := newClient(t, backend)
+
+	policy, err := loadPolicy(tempFile(t, mockPolicy).Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	driver := newPolicyDriver(t, client, policy)
+
+	labels := map[string]string{
+		LabelVault: mockVaultTitle,
+		LabelItem:  mockItemTitle,
+		LabelField: mockItemFieldLabel,
+	}
+
+	tests := []struct {
+		name    string
+		req     secrets.Request
+		want    string
+		wantErr string
+	}{
+		{
+			name: "allowed",
+			req: secrets.Request{
+				SecretName:   "foo",
+				SecretLabels: labels,
+				ServiceName:  "web-frontend",
+			},
+			want: mockItemFieldValue,
+		},
+		{
+			name: "denied",
+			req: secrets.Request{
+				SecretName:   "foo",
+				SecretLabels: labels,
+				ServiceName:  "db",
+			},
+			wantErr: fmt.Errorf("%w: service %q may not read secret %q", ErrAccessDenied, "db", "foo").Error(),
+		},
+		{
+			name: "vault ID matched on its title",
+			req: secrets.Request{
+				SecretName: "foo",
+				SecretLabels: map[string]string{
+					LabelVaultID: mockVaultUUID,
+					LabelItem:    mockItemTitle,
+					LabelField:   mockItemFieldLabel,
+				},
+				ServiceName: "web-frontend",
+			},
+			want: mockItemFieldValue,
+		},
+		{
+			name: "denied service on a missing vault",
+			req: secrets.Request{
+				SecretName: "foo",
+				SecretLabels: map[string]string{
+					LabelVault: "non-existent",
+					LabelItem:  mockItemTitle,
+					LabelField: mockItemFieldLabel,
+				},
+				ServiceName: "db",
+			},
+			wantErr: fmt.Errorf("%w: service %q may not read secret %q", ErrAccessDenied, "db", "foo").Error(),
+		},
+		{
+			name: "template reading a denied item",
+			req: secrets.Request{
+				SecretName: "foo",
+				SecretLabels: map[string]string{
+					LabelVault:    mockVaultTitle,
+					LabelItem:     mockItemTitle,
+					LabelTemplate: `{{ field "Field" }}:{{ item "Postgres" "password" }}`,
+				},
+				ServiceName: "web-frontend",
+			},
+			wantErr: fmt.Errorf("%w: service %q may not read secret %q", ErrAccessDenied, "web-frontend", "foo").Error(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := driver.Get(tt.req)
+			if tt.wantErr != "" {
+				if !strings.HasSuffix(got.Err, tt.wantErr) {
+					t.Errorf("OnePasswordDriver.Get() error = %v, want %v", got.Err, tt.wantErr)
+				}
+				return
+			}
+
+			if got.Err != "" || string(got.Value) != tt.want {
+				t.Errorf("OnePasswordDriver.Get() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOnePasswordDriver_Get_policyResolvedVault(t *testing.T) {
+	backend := newBackend(t, mockToken)
+	defer backend.Close()
+	client := newClient(t, backend)
+
+	// the pattern matches the label, but not the vault it resolves to
+	policy, err := loadPolicy(tempFile(t, `{
+  "rules": [
+    {
+      "service": "web-*",
+      "vaults": ["Test?"],
+      "items": ["*"],
+      "fields": ["*"]
+    }
+  ]
+}`).Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	driver := newPolicyDriver(t, client, policy)
+
+	got := driver.Get(secrets.Request{
+		SecretName: "foo",
+		SecretLabels: map[string]string{
+			LabelVault: mockVaultTitle + " ",
+			LabelItem:  mockItemTitle,
+			LabelField: mockItemFieldLabel,
+		},
+		ServiceName: "web-frontend",
+	})
+
+	if !strings.Contains(got.Err, ErrAccessDenied.Error()) {
+		t.Errorf("OnePasswordDriver.Get() = %v, want %v", got, ErrAccessDenied)
+	}
+}
+
+func newPolicyDriver(tb testing.TB, client connect.Client, policy *policy) secrets.Driver {
+	tb.Helper()
+
+	driver, err := New(client, common.DiscardLogger(), &config{
+		Policy: policy,
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return driver
+}
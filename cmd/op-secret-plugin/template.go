@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/1Password/connect-sdk-go/onepassword"
+)
+
+// renderTemplate executes a secret template. Its field function reads a field
+// of the secret item, and its item function reads a field of another item in
+// the same vault. Both take an optional section before the field name, and
+// fail the rendering if the field is missing or denied by the policy
+func (driver *onePasswordDriver) renderTemplate(lookup *secretLookup, item *onepassword.Item, text string) (string, error) {
+	funcs := template.FuncMap{
+		"field": func(path ...string) (string, error) {
+			section, name, err := splitFieldPath(path)
+			if err != nil {
+				return "", err
+			}
+
+			return driver.readField(lookup, item, section, name)
+		},
+		"item": func(title string, path ...string) (string, error) {
+			section, name, err := splitFieldPath(path)
+			if err != nil {
+				return "", err
+			}
+
+			other, err := driver.getItem(lookup.logger, &labels{Item: title}, lookup.vault.ID, lookup.reusable)
+			if err != nil {
+				return "", fmt.Errorf("%s: %w", title, err)
+			}
+
+			return driver.readField(lookup, other, section, name)
+		},
+	}
+
+	tmpl, err := template.New("secret").Funcs(funcs).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrInvalidTemplate, err)
+	}
+
+	var output strings.Builder
+	err = tmpl.Execute(&output, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return output.String(), nil
+}
+
+// splitFieldPath takes the optional section and the field name from the
+// template function arguments
+func splitFieldPath(path []string) (string, string, error) {
+	switch len(path) {
+	case 1:
+		return "", path[0], nil
+	case 2:
+		return path[0], path[1], nil
+	default:
+		return "", "", fmt.Errorf("expected a field name with an optional section, got %d argument(s)", len(path))
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_otpKey_code(t *testing.T) {
+	// test vectors from RFC 6238 appendix B
+	const (
+		secretSHA1   = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+		secretSHA256 = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZA"
+		secretSHA512 = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNA"
+	)
+
+	tests := []struct {
+		name    string
+		value   string
+		at      int64
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "SHA1",
+			value: "otpauth://totp/Test?secret=" + secretSHA1 + "&digits=8",
+			at:    59,
+			want:  "94287082",
+		},
+		{
+			name:  "SHA1 leading zero",
+			value: "otpauth://totp/Test?secret=" + secretSHA1 + "&digits=8&algorithm=SHA1",
+			at:    1111111109,
+			want:  "07081804",
+		},
+		{
+			name:  "SHA256",
+			value: "otpauth://totp/Test?secret=" + secretSHA256 + "&digits=8&algorithm=SHA256",
+			at:    1111111111,
+			want:  "67062674",
+		},
+		{
+			name:  "SHA512",
+			value: "otpauth://totp/Test?secret=" + secretSHA512 + "&digits=8&algorithm=sha512",
+			at:    20000000000,
+			want:  "47863826",
+		},
+		{
+			name:  "defaults",
+			value: "otpauth://totp/Test?secret=" + secretSHA1,
+			at:    59,
+			want:  "287082",
+		},
+		{
+			name:  "lowercase grouped secret",
+			value: "otpauth://totp/Test?secret=gezd%20gnbv%20gy3t%20qojq%20gezd%20gnbv%20gy3t%20qojq",
+			at:    59,
+			want:  "287082",
+		},
+		{
+			name:    "bare secret",
+			value:   secretSHA1,
+			wantErr: true,
+		},
+		{
+			name:  "period",
+			value: "otpauth://totp/Test?secret=" + secretSHA1 + "&period=60",
+			at:    119,
+			want:  "287082",
+		},
+		{
+			name:    "counter-based",
+			value:   "otpauth://hotp/Test?secret=" + secretSHA1,
+			wantErr: true,
+		},
+		{
+			name:    "unknown algorithm",
+			value:   "otpauth://totp/Test?secret=" + secretSHA1 + "&algorithm=MD5",
+			wantErr: true,
+		},
+		{
+			name:    "invalid digits",
+			value:   "otpauth://totp/Test?secret=" + secretSHA1 + "&digits=4",
+			wantErr: true,
+		},
+		{
+			name:    "missing secret",
+			value:   "otpauth://totp/Test",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := parseOTPKey(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseOTPKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if got := key.code(time.Unix(tt.at, 0)); got != tt.want {
+				t.Errorf("otpKey.code() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,14 @@
+package common
+
+import "os"
+
+// GetEnvDefault returns the environment variable value, or the fallback value
+// if it is unset or empty
+func GetEnvDefault(key string, fallback string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	return value
+}
@@ -0,0 +1,31 @@
+package common
+
+import "testing"
+
+func TestGetEnvDefault(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{
+			name:  "set",
+			value: "foo",
+			want:  "foo",
+		},
+		{
+			name:  "empty",
+			value: "",
+			want:  "fallback",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("COMMON_TEST_VALUE", tt.value)
+
+			if got := GetEnvDefault("COMMON_TEST_VALUE", "fallback"); got != tt.want {
+				t.Errorf("GetEnvDefault() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/docker/go-connections/sockets"
+)
+
+const (
+	// ExitOK is returned after a clean shutdown
+	ExitOK int = 0
+	// ExitServeError is returned when the plugin socket fails
+	ExitServeError int = 1
+	// ExitCleanupError is returned when a shutdown hook fails
+	ExitCleanupError int = 2
+	// ExitDrainTimeout is returned when requests are still in flight after the
+	// drain timeout
+	ExitDrainTimeout int = 3
+)
+
+// DefaultDrainTimeout is how long to wait for in-flight requests on shutdown
+const DefaultDrainTimeout = 10 * time.Second
+
+// drainPollInterval is how often the in-flight requests are checked on drain
+const drainPollInterval = 10 * time.Millisecond
+
+// pluginSocketDir is where dockerd looks for plugin sockets
+var pluginSocketDir = "/run/docker/plugins"
+
+// Server serves plugin requests on a listener, as the plugin helper handlers do
+type Server interface {
+	Serve(listener net.Listener) error
+}
+
+type shutdownHook struct {
+	name string
+	hook func(ctx context.Context) error
+}
+
+// Lifecycle serves a plugin until it is asked to stop, then drains the
+// in-flight requests and runs the shutdown hooks
+type Lifecycle struct {
+	name   string
+	gid    int
+	logger *Logger
+	// DrainTimeout limits how long to wait for in-flight requests on shutdown
+	DrainTimeout time.Duration
+	hooks        []shutdownHook
+}
+
+// NewLifecycle creates a lifecycle for the plugin socket name
+func NewLifecycle(name string, gid int, logger *Logger) *Lifecycle {
+	return &Lifecycle{
+		name:         name,
+		gid:          gid,
+		logger:       logger,
+		DrainTimeout: DefaultDrainTimeout,
+	}
+}
+
+// OnShutdown registers a hook to run after the requests are drained. Hooks run
+// in registration order, even if previous ones fail. Their context is done
+// once the drain timeout expires, so those must not wait on stuck requests
+// after that
+func (lifecycle *Lifecycle) OnShutdown(name string, hook func(ctx context.Context) error) {
+	lifecycle.hooks = append(lifecycle.hooks, shutdownHook{name, hook})
+}
+
+// Serve handles the plugin requests until SIGTERM or SIGINT, and returns the
+// process exit code
+func (lifecycle *Lifecycle) Serve(server Server) int {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(signals)
+
+	listener, _, err := newUnixListener(lifecycle.name, lifecycle.gid)
+	if err != nil {
+		lifecycle.logger.Error("failed to create the plugin socket", "error", err)
+		lifecycle.shutdownTimeout()
+		return ExitServeError
+	}
+
+	return lifecycle.serve(server, listener, signals)
+}
+
+func (lifecycle *Lifecycle) serve(server Server, listener net.Listener, signals <-chan os.Signal) int {
+	tracker := newDrainListener(listener)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.Serve(tracker)
+	}()
+
+	select {
+	case err := <-errs:
+		lifecycle.logger.Error("failed to serve requests", "error", err)
+		tracker.Close()
+		tracker.closeIdle()
+		lifecycle.shutdownTimeout()
+
+		return ExitServeError
+	case sig := <-signals:
+		lifecycle.logger.Info("shutting down", "signal", sig)
+	}
+
+	// stops accepting connections; the server error is expected from now on
+	tracker.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), lifecycle.DrainTimeout)
+	defer cancel()
+
+	code := ExitOK
+
+	err := tracker.drain(ctx)
+	if err != nil {
+		lifecycle.logger.Warn("requests still in flight", "count", tracker.active(), "timeout", lifecycle.DrainTimeout)
+		code = ExitDrainTimeout
+	}
+
+	// hooks share the drain deadline, so a stuck request cannot block those
+	if !lifecycle.shutdown(ctx) && code == ExitOK {
+		code = ExitCleanupError
+	}
+
+	return code
+}
+
+// shutdownTimeout runs all hooks limited by the drain timeout, for when there
+// are no requests to drain
+func (lifecycle *Lifecycle) shutdownTimeout() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), lifecycle.DrainTimeout)
+	defer cancel()
+
+	return lifecycle.shutdown(ctx)
+}
+
+// shutdown runs all hooks, and reports if they all succeeded
+func (lifecycle *Lifecycle) shutdown(ctx context.Context) bool {
+	ok := true
+
+	for _, entry := range lifecycle.hooks {
+		err := entry.hook(ctx)
+		if err != nil {
+			lifecycle.logger.Error("shutdown hook failed", "hook", entry.name, "error", err)
+			ok = false
+		}
+	}
+
+	return ok
+}
+
+// newUnixListener creates the plugin socket where dockerd looks for it
+// see github.com/docker/go-plugins-helpers/sdk.newUnixListener
+func newUnixListener(pluginName string, gid int) (net.Listener, string, error) {
+	err := os.MkdirAll(pluginSocketDir, 0755)
+	if err != nil {
+		return nil, "", err
+	}
+
+	path := pluginName
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(pluginSocketDir, pluginName+".sock")
+	}
+
+	listener, err := sockets.NewUnixSocket(path, gid)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return listener, path, nil
+}
+
+// drainListener tracks the accepted connections, so shutdown can wait for the
+// ones handling a request. A connection is busy from the moment it reads a
+// request until it writes the response, as plugin clients do not pipeline
+type drainListener struct {
+	net.Listener
+	mu    sync.Mutex
+	conns map[*drainConn]bool
+}
+
+func newDrainListener(listener net.Listener) *drainListener {
+	return &drainListener{
+		Listener: listener,
+		conns:    make(map[*drainConn]bool),
+	}
+}
+
+func (listener *drainListener) Accept() (net.Conn, error) {
+	conn, err := listener.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	tracked := &drainConn{Conn: conn, listener: listener}
+
+	listener.mu.Lock()
+	listener.conns[tracked] = false
+	listener.mu.Unlock()
+
+	return tracked, nil
+}
+
+func (listener *drainListener) setBusy(conn *drainConn, busy bool) {
+	listener.mu.Lock()
+	defer listener.mu.Unlock()
+
+	if _, exists := listener.conns[conn]; exists {
+		listener.conns[conn] = busy
+	}
+}
+
+func (listener *drainListener) remove(conn *drainConn) {
+	listener.mu.Lock()
+	defer listener.mu.Unlock()
+
+	delete(listener.conns, conn)
+}
+
+// active returns the number of connections handling a request
+func (listener *drainListener) active() int {
+	listener.mu.Lock()
+	defer listener.mu.Unlock()
+
+	count := 0
+	for _, busy := range listener.conns {
+		if busy {
+			count++
+		}
+	}
+
+	return count
+}
+
+// closeIdle closes the connections waiting for a request
+func (listener *drainListener) closeIdle() {
+	listener.mu.Lock()
+	idle := make([]*drainConn, 0, len(listener.conns))
+	for conn, busy := range listener.conns {
+		if !busy {
+			idle = append(idle, conn)
+		}
+	}
+	listener.mu.Unlock()
+
+	for _, conn := range idle {
+		conn.Close()
+	}
+}
+
+// drain waits for the busy connections to finish, closing the idle ones so
+// those cannot start new requests
+func (listener *drainListener) drain(ctx context.Context) error {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for {
+		listener.closeIdle()
+
+		if listener.active() == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+type drainConn struct {
+	net.Conn
+	listener *drainListener
+}
+
+func (conn *drainConn) Read(b []byte) (int, error) {
+	n, err := conn.Conn.Read(b)
+	if n > 0 {
+		conn.listener.setBusy(conn, true)
+	}
+
+	return n, err
+}
+
+func (conn *drainConn) Write(b []byte) (int, error) {
+	n, err := conn.Conn.Write(b)
+	conn.listener.setBusy(conn, false)
+
+	return n, err
+}
+
+func (conn *drainConn) Close() error {
+	conn.listener.remove(conn)
+
+	err := conn.Conn.Close()
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+
+	return err
+}
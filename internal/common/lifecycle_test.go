@@ -0,0 +1,206 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type serverFunc func(listener net.Listener) error
+
+func (fn serverFunc) Serve(listener net.Listener) error {
+	return fn(listener)
+}
+
+// newSlowServer serves requests that only finish once release is closed, and
+// signals on started when each one begins
+func newSlowServer(started chan<- struct{}, release <-chan struct{}) Server {
+	return serverFunc(func(listener net.Listener) error {
+		return http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			started <- struct{}{}
+			<-release
+			fmt.Fprint(w, "done")
+		}))
+	})
+}
+
+func newTestListener(tb testing.TB) (net.Listener, *http.Client) {
+	tb.Helper()
+
+	socket := filepath.Join(tb.TempDir(), "test.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+
+	return listener, client
+}
+
+func TestLifecycle_serve(t *testing.T) {
+	tests := []struct {
+		name         string
+		drainTimeout time.Duration
+		hookErr      error
+		releaseAfter time.Duration
+		want         int
+	}{
+		{
+			name:         "drained",
+			drainTimeout: time.Second,
+			releaseAfter: 50 * time.Millisecond,
+			want:         ExitOK,
+		},
+		{
+			name:         "drain timeout",
+			drainTimeout: 20 * time.Millisecond,
+			releaseAfter: 200 * time.Millisecond,
+			want:         ExitDrainTimeout,
+		},
+		{
+			name:         "failed hook",
+			drainTimeout: time.Second,
+			hookErr:      fmt.Errorf("common: test"),
+			want:         ExitCleanupError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener, client := newTestListener(t)
+			started := make(chan struct{}, 1)
+			release := make(chan struct{})
+			signals := make(chan os.Signal, 1)
+
+			lifecycle := NewLifecycle("test", 0, DiscardLogger())
+			lifecycle.DrainTimeout = tt.drainTimeout
+
+			var drained, expired bool
+			lifecycle.OnShutdown("test", func(ctx context.Context) error {
+				select {
+				case <-release:
+					drained = true
+				default:
+				}
+				expired = ctx.Err() != nil
+				return tt.hookErr
+			})
+
+			codes := make(chan int, 1)
+			go func() {
+				codes <- lifecycle.serve(newSlowServer(started, release), listener, signals)
+			}()
+
+			responses := make(chan string, 1)
+			go func() {
+				resp, err := client.Get("http://plugin/test")
+				if err != nil {
+					responses <- err.Error()
+					return
+				}
+				defer resp.Body.Close()
+
+				body, _ := io.ReadAll(resp.Body)
+				responses <- string(body)
+			}()
+
+			<-started
+			signals <- syscall.SIGTERM
+
+			time.Sleep(tt.releaseAfter)
+			close(release)
+
+			got := <-codes
+			if got != tt.want {
+				t.Errorf("Lifecycle.serve() = %d, want %d", got, tt.want)
+			}
+
+			if tt.want == ExitOK && !drained {
+				t.Errorf("Lifecycle.serve() ran the hooks before draining the requests")
+			}
+
+			if tt.want == ExitDrainTimeout && !expired {
+				t.Errorf("Lifecycle.serve() ran the hooks without the drain deadline")
+			}
+
+			if tt.want == ExitOK {
+				if body := <-responses; body != "done" {
+					t.Errorf("in-flight response = %q, want done", body)
+				}
+			}
+		})
+	}
+}
+
+func TestLifecycle_serve_error(t *testing.T) {
+	listener, _ := newTestListener(t)
+
+	lifecycle := NewLifecycle("test", 0, DiscardLogger())
+
+	var ran bool
+	lifecycle.OnShutdown("test", func(ctx context.Context) error {
+		ran = true
+		return nil
+	})
+
+	got := lifecycle.serve(serverFunc(func(listener net.Listener) error {
+		return fmt.Errorf("common: test")
+	}), listener, make(chan os.Signal))
+
+	if got != ExitServeError {
+		t.Errorf("Lifecycle.serve() = %d, want %d", got, ExitServeError)
+	}
+
+	if !ran {
+		t.Errorf("Lifecycle.serve() did not run the shutdown hooks")
+	}
+}
+
+func Test_drainListener_closeIdle(t *testing.T) {
+	listener, client := newTestListener(t)
+	tracker := newDrainListener(listener)
+	defer tracker.Close()
+
+	go http.Serve(tracker, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "done")
+	}))
+
+	resp, err := client.Get("http://plugin/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if got := tracker.active(); got != 0 {
+		t.Errorf("drainListener.active() = %d, want 0 after the response", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	err = tracker.drain(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	if len(tracker.conns) != 0 {
+		t.Errorf("drainListener.drain() left %d idle connection(s) open", len(tracker.conns))
+	}
+}
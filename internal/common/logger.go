@@ -0,0 +1,253 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// EnvLogLevel is the minimum log level environment variable name
+	EnvLogLevel string = `LOG_LEVEL`
+	// EnvLogFormat is the log output format environment variable name
+	EnvLogFormat string = `LOG_FORMAT`
+)
+
+const (
+	// FormatLogfmt writes entries as space-separated key=value pairs
+	FormatLogfmt string = "logfmt"
+	// FormatJSON writes entries as JSON objects, one per line
+	FormatJSON string = "json"
+)
+
+// Level is the severity of a log entry
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+func (level Level) String() string {
+	name, exists := levelNames[level]
+	if !exists {
+		return strconv.Itoa(int(level))
+	}
+
+	return name
+}
+
+// ParseLevel parses a level name, case-insensitive
+func ParseLevel(value string) (Level, error) {
+	for level, name := range levelNames {
+		if strings.EqualFold(value, name) {
+			return level, nil
+		}
+	}
+
+	return LevelInfo, fmt.Errorf("unknown log level %q", value)
+}
+
+// redactedKeys are field names whose values are never written
+var redactedKeys = map[string]bool{
+	"credentials": true,
+	"pass":        true,
+	"password":    true,
+	"secret":      true,
+	"token":       true,
+	"value":       true,
+}
+
+const redacted = "<redacted>"
+
+// Logger writes levelled entries with a message and key-value fields. Fields
+// named after secrets, such as password, token or value, are always redacted
+type Logger struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	level  Level
+	format string
+	fields []interface{}
+	now    func() time.Time
+}
+
+// NewLogger creates a logger that writes entries at or above the level
+func NewLogger(out io.Writer, level Level, format string) (*Logger, error) {
+	if format != FormatLogfmt && format != FormatJSON {
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+
+	return &Logger{
+		mu:     &sync.Mutex{},
+		out:    out,
+		level:  level,
+		format: format,
+		now:    time.Now,
+	}, nil
+}
+
+// NewLoggerFromEnv creates a stderr logger for the plugin, using the level and
+// format environment settings
+func NewLoggerFromEnv(plugin string) (*Logger, error) {
+	level, err := ParseLevel(GetEnvDefault(EnvLogLevel, LevelInfo.String()))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", EnvLogLevel, err)
+	}
+
+	logger, err := NewLogger(os.Stderr, level, GetEnvDefault(EnvLogFormat, FormatLogfmt))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", EnvLogFormat, err)
+	}
+
+	return logger.With("plugin", plugin), nil
+}
+
+// DiscardLogger returns a logger that writes nothing
+func DiscardLogger() *Logger {
+	logger, _ := NewLogger(io.Discard, LevelError+1, FormatLogfmt)
+	return logger
+}
+
+// With returns a logger that adds the key-value pairs to all entries
+func (logger *Logger) With(keyvals ...interface{}) *Logger {
+	child := *logger
+	child.fields = append(append(make([]interface{}, 0, len(logger.fields)+len(keyvals)), logger.fields...), keyvals...)
+
+	return &child
+}
+
+// Enabled reports if entries of the level are written
+func (logger *Logger) Enabled(level Level) bool {
+	return level >= logger.level
+}
+
+func (logger *Logger) Debug(msg string, keyvals ...interface{}) {
+	logger.log(LevelDebug, msg, keyvals)
+}
+
+func (logger *Logger) Info(msg string, keyvals ...interface{}) {
+	logger.log(LevelInfo, msg, keyvals)
+}
+
+func (logger *Logger) Warn(msg string, keyvals ...interface{}) {
+	logger.log(LevelWarn, msg, keyvals)
+}
+
+func (logger *Logger) Error(msg string, keyvals ...interface{}) {
+	logger.log(LevelError, msg, keyvals)
+}
+
+func (logger *Logger) log(level Level, msg string, keyvals []interface{}) {
+	if !logger.Enabled(level) {
+		return
+	}
+
+	entry := make([]interface{}, 0, 6+len(logger.fields)+len(keyvals))
+	entry = append(entry, "time", logger.now().UTC().Format(time.RFC3339Nano), "level", level.String(), "msg", msg)
+	entry = append(entry, logger.fields...)
+	entry = append(entry, keyvals...)
+
+	var line bytes.Buffer
+	if logger.format == FormatJSON {
+		encodeJSON(&line, entry)
+	} else {
+		encodeLogfmt(&line, entry)
+	}
+	line.WriteByte('\n')
+
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+
+	logger.out.Write(line.Bytes()) //nolint:errcheck
+}
+
+// fieldPairs walks the key-value pairs, stringifying keys and values and
+// redacting the sensitive ones. A missing value is reported as such
+func fieldPairs(keyvals []interface{}, fn func(key string, value string)) {
+	for index := 0; index < len(keyvals); index += 2 {
+		key := fmt.Sprint(keyvals[index])
+
+		value := "<missing>"
+		if index+1 < len(keyvals) {
+			value = formatValue(keyvals[index+1])
+		}
+
+		if redactedKeys[strings.ToLower(key)] {
+			value = redacted
+		}
+
+		fn(key, value)
+	}
+}
+
+func formatValue(value interface{}) string {
+	switch typed := value.(type) {
+	case nil:
+		return "<nil>"
+	case error:
+		return typed.Error()
+	case time.Duration:
+		return typed.String()
+	case fmt.Stringer:
+		return typed.String()
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+func encodeLogfmt(out *bytes.Buffer, keyvals []interface{}) {
+	first := true
+
+	fieldPairs(keyvals, func(key string, value string) {
+		if !first {
+			out.WriteByte(' ')
+		}
+		first = false
+
+		out.WriteString(key)
+		out.WriteByte('=')
+
+		if value == "" || strings.ContainsAny(value, " =\"\t\r\n") {
+			out.WriteString(strconv.Quote(value))
+		} else {
+			out.WriteString(value)
+		}
+	})
+}
+
+func encodeJSON(out *bytes.Buffer, keyvals []interface{}) {
+	out.WriteByte('{')
+	first := true
+
+	fieldPairs(keyvals, func(key string, value string) {
+		if !first {
+			out.WriteByte(',')
+		}
+		first = false
+
+		// marshalling strings never fails
+		keyData, _ := json.Marshal(key)
+		valueData, _ := json.Marshal(value)
+
+		out.Write(keyData)
+		out.WriteByte(':')
+		out.Write(valueData)
+	})
+
+	out.WriteByte('}')
+}
@@ -0,0 +1,126 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func newTestLogger(tb testing.TB, level Level, format string) (*Logger, *bytes.Buffer) {
+	tb.Helper()
+
+	var out bytes.Buffer
+	logger, err := NewLogger(&out, level, format)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	logger.now = func() time.Time {
+		return time.Date(2022, time.June, 1, 12, 0, 0, 0, time.UTC)
+	}
+
+	return logger, &out
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Level
+		wantErr bool
+	}{
+		{value: "debug", want: LevelDebug},
+		{value: "INFO", want: LevelInfo},
+		{value: "Warn", want: LevelWarn},
+		{value: "error", want: LevelError},
+		{value: "verbose", want: LevelInfo, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseLevel(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseLevel() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseLevel() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	_, err := NewLogger(&bytes.Buffer{}, LevelInfo, "xml")
+	if err == nil {
+		t.Errorf("NewLogger() error = nil, want error on an unknown format")
+	}
+}
+
+func TestLogger_logfmt(t *testing.T) {
+	logger, out := newTestLogger(t, LevelInfo, FormatLogfmt)
+
+	logger.With("plugin", "test").Info("mounted share", "volume", "foo bar", "error", fmt.Errorf("common: test"), "id")
+
+	want := `time=2022-06-01T12:00:00Z level=info msg="mounted share" plugin=test volume="foo bar" error="common: test" id=<missing>` + "\n"
+	if out.String() != want {
+		t.Errorf("Logger.Info() = %q, want %q", out.String(), want)
+	}
+}
+
+func TestLogger_json(t *testing.T) {
+	logger, out := newTestLogger(t, LevelDebug, FormatJSON)
+
+	logger.Debug("lookup", "vault", "Test", "count", 2)
+
+	var got map[string]string
+	err := json.Unmarshal(out.Bytes(), &got)
+	if err != nil {
+		t.Fatalf("Logger.Debug() output is not JSON: %v", err)
+	}
+
+	want := map[string]string{
+		"time":  "2022-06-01T12:00:00Z",
+		"level": "debug",
+		"msg":   "lookup",
+		"vault": "Test",
+		"count": "2",
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("Logger.Debug() %s = %q, want %q", key, got[key], value)
+		}
+	}
+}
+
+func TestLogger_level(t *testing.T) {
+	logger, out := newTestLogger(t, LevelWarn, FormatLogfmt)
+
+	logger.Debug("debug")
+	logger.Info("info")
+	if out.Len() != 0 {
+		t.Errorf("Logger wrote entries below its level: %q", out.String())
+	}
+
+	logger.Warn("warn")
+	logger.Error("error")
+	if lines := bytes.Count(out.Bytes(), []byte("\n")); lines != 2 {
+		t.Errorf("Logger wrote %d entries, want 2", lines)
+	}
+}
+
+func TestLogger_redaction(t *testing.T) {
+	for _, format := range []string{FormatLogfmt, FormatJSON} {
+		t.Run(format, func(t *testing.T) {
+			logger, out := newTestLogger(t, LevelInfo, format)
+
+			logger.With("token", "very-secret").Info("request", "password", "hunter2", "Value", "dolor sit amet")
+
+			for _, secret := range []string{"very-secret", "hunter2", "dolor sit amet"} {
+				if bytes.Contains(out.Bytes(), []byte(secret)) {
+					t.Errorf("Logger wrote secret %q: %s", secret, out.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+)
+
+// startup exit codes, following the sysexits.h values
+const (
+	// ExitStartupError is returned on unclassified startup failures
+	ExitStartupError int = 70
+	// ExitBackendUnavailable is returned when a required service is unreachable
+	ExitBackendUnavailable int = 69
+	// ExitPermissionError is returned when the plugin lacks access to a resource
+	ExitPermissionError int = 77
+	// ExitConfigError is returned on invalid settings
+	ExitConfigError int = 78
+)
+
+// ConfigError is a startup failure caused by invalid settings
+type ConfigError struct {
+	Err error
+}
+
+func (err *ConfigError) Error() string {
+	return fmt.Sprintf("invalid configuration: %s", err.Err)
+}
+
+func (err *ConfigError) Unwrap() error {
+	return err.Err
+}
+
+// BackendUnavailable is a startup failure to reach a service the plugin
+// depends on
+type BackendUnavailable struct {
+	Backend string
+	Err     error
+}
+
+func (err *BackendUnavailable) Error() string {
+	return fmt.Sprintf("%s is unavailable: %s", err.Backend, err.Err)
+}
+
+func (err *BackendUnavailable) Unwrap() error {
+	return err.Err
+}
+
+// PermissionError is a startup failure to access a resource
+type PermissionError struct {
+	Err error
+}
+
+func (err *PermissionError) Error() string {
+	return fmt.Sprintf("insufficient permissions: %s", err.Err)
+}
+
+func (err *PermissionError) Unwrap() error {
+	return err.Err
+}
+
+// ExitCode maps a startup error to the process exit code. Permission failures
+// take precedence, as those are usually the root cause of the others
+func ExitCode(err error) int {
+	var configErr *ConfigError
+	var backendErr *BackendUnavailable
+	var permissionErr *PermissionError
+
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.As(err, &permissionErr), errors.Is(err, fs.ErrPermission):
+		return ExitPermissionError
+	case errors.As(err, &configErr):
+		return ExitConfigError
+	case errors.As(err, &backendErr):
+		return ExitBackendUnavailable
+	default:
+		return ExitStartupError
+	}
+}
+
+// StartupFailed writes the error as a single line and returns its exit code
+func StartupFailed(out io.Writer, err error) int {
+	fmt.Fprintln(out, strings.Join(strings.Fields(err.Error()), " "))
+
+	return ExitCode(err)
+}
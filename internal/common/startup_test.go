@@ -0,0 +1,73 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{
+			name: "no error",
+			err:  nil,
+			want: ExitOK,
+		},
+		{
+			name: "config error",
+			err:  &ConfigError{Err: fmt.Errorf("common: test")},
+			want: ExitConfigError,
+		},
+		{
+			name: "wrapped config error",
+			err:  fmt.Errorf("wrapped: %w", &ConfigError{Err: fmt.Errorf("common: test")}),
+			want: ExitConfigError,
+		},
+		{
+			name: "backend unavailable",
+			err:  &BackendUnavailable{Backend: "test", Err: fmt.Errorf("common: test")},
+			want: ExitBackendUnavailable,
+		},
+		{
+			name: "permission error",
+			err:  &PermissionError{Err: fmt.Errorf("common: test")},
+			want: ExitPermissionError,
+		},
+		{
+			name: "config error caused by permissions",
+			err:  &ConfigError{Err: &os.PathError{Op: "open", Path: "test", Err: os.ErrPermission}},
+			want: ExitPermissionError,
+		},
+		{
+			name: "unclassified error",
+			err:  fmt.Errorf("common: test"),
+			want: ExitStartupError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExitCode(tt.err); got != tt.want {
+				t.Errorf("ExitCode() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStartupFailed(t *testing.T) {
+	var out bytes.Buffer
+
+	got := StartupFailed(&out, &ConfigError{Err: fmt.Errorf("common:\ntest")})
+	if got != ExitConfigError {
+		t.Errorf("StartupFailed() = %d, want %d", got, ExitConfigError)
+	}
+
+	want := "invalid configuration: common: test\n"
+	if out.String() != want {
+		t.Errorf("StartupFailed() output = %q, want %q", out.String(), want)
+	}
+}
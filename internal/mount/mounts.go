@@ -5,9 +5,24 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"io"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
 )
 
+const (
+	// ProcMountsPath is the mount table in the fstab format
+	ProcMountsPath = "/proc/mounts"
+	// ProcMountInfoPath is the mount table of the current process in the
+	// mountinfo format
+	ProcMountInfoPath = "/proc/self/mountinfo"
+)
+
+// mountInfoSeparator splits the mountinfo optional fields from the filesystem
+// specific ones
+const mountInfoSeparator = "-"
+
 type mountOptions map[string]string
 
 func NewMountOptions(data []byte) (*mountOptions, error) {
@@ -16,10 +31,18 @@ func NewMountOptions(data []byte) (*mountOptions, error) {
 	return &mntOptions, err
 }
 
+// MarshalText encodes the options sorted by key, so the output is stable
 func (mntOptions mountOptions) MarshalText() ([]byte, error) {
+	keys := make([]string, 0, len(mntOptions))
+	for key := range mntOptions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
 	options := make([]string, 0, len(mntOptions))
 
-	for key, value := range mntOptions {
+	for _, key := range keys {
+		value := mntOptions[key]
 		if value == "" {
 			options = append(options, key)
 		} else {
@@ -34,6 +57,10 @@ func (mntOptions mountOptions) UnmarshalText(text []byte) error {
 	entries := bytes.Split(text, []byte(","))
 
 	for _, entry := range entries {
+		if len(entry) == 0 {
+			continue
+		}
+
 		parts := bytes.SplitN(entry, []byte("="), 2)
 		key := string(parts[0])
 		switch len(parts) {
@@ -58,6 +85,61 @@ func (mntOptions mountOptions) String() string {
 	return string(data)
 }
 
+// Get returns an option value and whether it is set
+func (mntOptions mountOptions) Get(key string) (string, bool) {
+	value, exists := mntOptions[key]
+	return value, exists
+}
+
+// unescape decodes the octal escapes the kernel uses for whitespace and
+// backslashes on mount table paths, e.g. \040 for a space
+func unescape(value string) (string, error) {
+	if !strings.Contains(value, `\`) {
+		return value, nil
+	}
+
+	var result strings.Builder
+	result.Grow(len(value))
+
+	for index := 0; index < len(value); index++ {
+		if value[index] != '\\' {
+			result.WriteByte(value[index])
+			continue
+		}
+
+		if index+3 >= len(value) {
+			return "", fmt.Errorf("invalid escape sequence on %q", value)
+		}
+
+		code, err := strconv.ParseUint(value[index+1:index+4], 8, 8)
+		if err != nil {
+			return "", fmt.Errorf("invalid escape sequence on %q: %w", value, err)
+		}
+
+		result.WriteByte(byte(code))
+		index += 3
+	}
+
+	return result.String(), nil
+}
+
+// escape encodes the characters that would break a mount table entry apart
+func escape(value string) string {
+	var result strings.Builder
+	result.Grow(len(value))
+
+	for index := 0; index < len(value); index++ {
+		switch char := value[index]; char {
+		case ' ', '\t', '\n', '\\':
+			fmt.Fprintf(&result, `\%03o`, char)
+		default:
+			result.WriteByte(char)
+		}
+	}
+
+	return result.String()
+}
+
 type procMount struct {
 	device  string
 	mount   string
@@ -65,30 +147,235 @@ type procMount struct {
 	options *mountOptions
 	dump    int
 	pass    int
+
+	// mountinfo-only fields
+	info         bool
+	id           int
+	parentID     int
+	majorMinor   string
+	root         string
+	optional     []string
+	superOptions *mountOptions
 }
 
+// NewProcMount parses a mount table line, either from /proc/mounts or from
+// /proc/self/mountinfo
 func NewProcMount(data []byte) (*procMount, error) {
 	mount := procMount{}
 	err := mount.UnmarshalText(data)
 	return &mount, err
 }
 
+// Device returns the mount source, such as a block device or a remote share
+func (mnt *procMount) Device() string {
+	return mnt.device
+}
+
+// Target returns the path the filesystem is mounted at
+func (mnt *procMount) Target() string {
+	return mnt.mount
+}
+
+// FsType returns the filesystem type
+func (mnt *procMount) FsType() string {
+	return mnt.fsType
+}
+
+// Options returns the per-mount options
+func (mnt *procMount) Options() *mountOptions {
+	return mnt.options
+}
+
+// SuperOptions returns the per-superblock options, only set on mountinfo entries
+func (mnt *procMount) SuperOptions() *mountOptions {
+	return mnt.superOptions
+}
+
+// Root returns the filesystem path mounted at the target, only set on
+// mountinfo entries
+func (mnt *procMount) Root() string {
+	return mnt.root
+}
+
+// ID returns the unique mount ID, only set on mountinfo entries
+func (mnt *procMount) ID() int {
+	return mnt.id
+}
+
+// ParentID returns the parent mount ID, only set on mountinfo entries
+func (mnt *procMount) ParentID() int {
+	return mnt.parentID
+}
+
 func (mnt *procMount) MarshalText() ([]byte, error) {
-	return nil, nil
+	options, err := mnt.options.MarshalText()
+	if err != nil {
+		return nil, err
+	}
+
+	if !mnt.info {
+		return []byte(fmt.Sprintf("%s %s %s %s %d %d",
+			escape(mnt.device),
+			escape(mnt.mount),
+			mnt.fsType,
+			options,
+			mnt.dump,
+			mnt.pass,
+		)), nil
+	}
+
+	superOptions, err := mnt.superOptions.MarshalText()
+	if err != nil {
+		return nil, err
+	}
+
+	fields := []string{
+		strconv.Itoa(mnt.id),
+		strconv.Itoa(mnt.parentID),
+		mnt.majorMinor,
+		escape(mnt.root),
+		escape(mnt.mount),
+		string(options),
+	}
+	fields = append(fields, mnt.optional...)
+	fields = append(fields,
+		mountInfoSeparator,
+		mnt.fsType,
+		escape(mnt.device),
+		string(superOptions),
+	)
+
+	return []byte(strings.Join(fields, " ")), nil
 }
 
 func (mnt *procMount) UnmarshalText(text []byte) error {
-	var options string
-	_, err := fmt.Sscanf(string(text), "%s %s %s %s %d %d", &mnt.device, &mnt.mount, &mnt.fsType, &options, &mnt.dump, &mnt.pass)
-	if err != nil && err != io.EOF {
-		return fmt.Errorf("failed to parse mount: %w", err)
+	fields := strings.Fields(string(text))
+
+	if isMountInfo(fields) {
+		return mnt.unmarshalMountInfo(fields)
+	}
+
+	return mnt.unmarshalMounts(fields)
+}
+
+// isMountInfo checks if the fields are from a mountinfo line, which starts
+// with the numeric mount and parent IDs and has a separator field
+func isMountInfo(fields []string) bool {
+	if len(fields) < 10 {
+		return false
 	}
 
-	mnt.options, err = NewMountOptions([]byte(options))
+	for _, field := range fields[:2] {
+		if _, err := strconv.Atoi(field); err != nil {
+			return false
+		}
+	}
+
+	for _, field := range fields[6:] {
+		if field == mountInfoSeparator {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (mnt *procMount) unmarshalMounts(fields []string) error {
+	if len(fields) != 6 {
+		return fmt.Errorf("failed to parse mount: expected 6 fields, got %d", len(fields))
+	}
+
+	var err error
+
+	mnt.device, err = unescape(fields[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse mount device: %w", err)
+	}
+
+	mnt.mount, err = unescape(fields[1])
+	if err != nil {
+		return fmt.Errorf("failed to parse mount target: %w", err)
+	}
+
+	mnt.fsType = fields[2]
+
+	mnt.options, err = NewMountOptions([]byte(fields[3]))
+	if err != nil {
+		return fmt.Errorf("failed to parse mount options: %w", err)
+	}
+
+	mnt.dump, err = strconv.Atoi(fields[4])
+	if err != nil {
+		return fmt.Errorf("failed to parse mount dump: %w", err)
+	}
+
+	mnt.pass, err = strconv.Atoi(fields[5])
+	if err != nil {
+		return fmt.Errorf("failed to parse mount pass: %w", err)
+	}
+
+	return nil
+}
+
+func (mnt *procMount) unmarshalMountInfo(fields []string) error {
+	var err error
+
+	mnt.info = true
+
+	mnt.id, err = strconv.Atoi(fields[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse mount ID: %w", err)
+	}
+
+	mnt.parentID, err = strconv.Atoi(fields[1])
+	if err != nil {
+		return fmt.Errorf("failed to parse mount parent ID: %w", err)
+	}
+
+	mnt.majorMinor = fields[2]
+
+	mnt.root, err = unescape(fields[3])
+	if err != nil {
+		return fmt.Errorf("failed to parse mount root: %w", err)
+	}
+
+	mnt.mount, err = unescape(fields[4])
+	if err != nil {
+		return fmt.Errorf("failed to parse mount target: %w", err)
+	}
+
+	mnt.options, err = NewMountOptions([]byte(fields[5]))
 	if err != nil {
 		return fmt.Errorf("failed to parse mount options: %w", err)
 	}
 
+	separator := 6
+	for fields[separator] != mountInfoSeparator {
+		separator++
+	}
+
+	mnt.optional = nil
+	if separator > 6 {
+		mnt.optional = append([]string{}, fields[6:separator]...)
+	}
+
+	rest := fields[separator+1:]
+	if len(rest) != 3 {
+		return fmt.Errorf("failed to parse mount: expected 3 fields after separator, got %d", len(rest))
+	}
+
+	mnt.fsType = rest[0]
+
+	mnt.device, err = unescape(rest[1])
+	if err != nil {
+		return fmt.Errorf("failed to parse mount source: %w", err)
+	}
+
+	mnt.superOptions, err = NewMountOptions([]byte(rest[2]))
+	if err != nil {
+		return fmt.Errorf("failed to parse mount super options: %w", err)
+	}
+
 	return nil
 }
 
@@ -109,11 +396,58 @@ type mountsCache struct {
 }
 
 func NewMountsCache(r io.Reader) (*mountsCache, error) {
-	mntReader := mountsCache{}
+	mntReader := mountsCache{
+		mounts: map[string]*procMount{},
+	}
 	_, err := mntReader.ReadFrom(r)
 	return &mntReader, err
 }
 
+// Refresh reloads the cache from a mount table file. It only parses the
+// content if it changed since the last read
+func (mounts *mountsCache) Refresh(name string) (bool, error) {
+	fd, err := os.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer fd.Close()
+
+	n, err := mounts.ReadFrom(fd)
+	return n > 0, err
+}
+
+// Get returns the mount entry on the target path, if any. Stacked mounts
+// return the topmost entry
+func (mounts *mountsCache) Get(target string) (*procMount, bool) {
+	mount, exists := mounts.mounts[target]
+	return mount, exists
+}
+
+// IsMounted checks if the target path is mounted with the given filesystem type
+func (mounts *mountsCache) IsMounted(target string, fsType string) bool {
+	mount, exists := mounts.Get(target)
+	return exists && mount.fsType == fsType
+}
+
+// ByFsType lists the mounts of a filesystem type, sorted by target path
+func (mounts *mountsCache) ByFsType(fsType string) []*procMount {
+	entries := make([]*procMount, 0)
+
+	for _, mount := range mounts.mounts {
+		if mount.fsType == fsType {
+			entries = append(entries, mount)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].mount < entries[j].mount
+	})
+
+	return entries
+}
+
+// ReadFrom loads a mount table. It returns zero bytes read and keeps the
+// current entries if the content checksum matches the last read one
 func (mounts *mountsCache) ReadFrom(r io.Reader) (n int64, err error) {
 	data, err := io.ReadAll(r)
 	if err != nil && err != io.EOF {
@@ -126,16 +460,22 @@ func (mounts *mountsCache) ReadFrom(r io.Reader) (n int64, err error) {
 	}
 
 	lines := bytes.Split(data, []byte("\n"))
+	entries := make(map[string]*procMount, len(lines))
 
 	for _, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
 		mount, err := NewProcMount(line)
 		if err != nil {
 			return 0, fmt.Errorf("failed to build new proc mount: %w", err)
 		}
 
-		mounts.mounts[mount.mount] = mount
+		entries[mount.mount] = mount
 	}
 
+	mounts.mounts = entries
 	mounts.checksum = checksum
 	return int64(len(data)), nil
 }
@@ -0,0 +1,365 @@
+package mount
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func openFixture(tb testing.TB, name string) *os.File {
+	tb.Helper()
+
+	fd, err := os.Open(path.Join("testdata", name))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { fd.Close() })
+
+	return fd
+}
+
+func TestNewMountOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want mountOptions
+	}{
+		{
+			name: "flags and values",
+			data: "rw,vers=3.0,uid=1000",
+			want: mountOptions{"rw": "", "vers": "3.0", "uid": "1000"},
+		},
+		{
+			name: "value with equal sign",
+			data: "lowerdir=/a=b",
+			want: mountOptions{"lowerdir": "/a=b"},
+		},
+		{
+			name: "empty",
+			data: "",
+			want: mountOptions{},
+		},
+		{
+			name: "empty entries",
+			data: "rw,,ro,",
+			want: mountOptions{"rw": "", "ro": ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewMountOptions([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("NewMountOptions() error = %v", err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("NewMountOptions() = %v, want %v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMountOptions_String(t *testing.T) {
+	options := mountOptions{"vers": "3.0", "rw": "", "uid": "1000"}
+
+	want := "rw,uid=1000,vers=3.0"
+	if got := options.String(); got != want {
+		t.Errorf("mountOptions.String() = %q, want %q", got, want)
+	}
+}
+
+func TestNewProcMount(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    *procMount
+		wantErr bool
+	}{
+		{
+			name: "mounts entry",
+			data: "//host/share /mnt/share cifs rw,vers=3.0 0 0",
+			want: &procMount{
+				device:  "//host/share",
+				mount:   "/mnt/share",
+				fsType:  "cifs",
+				options: &mountOptions{"rw": "", "vers": "3.0"},
+			},
+		},
+		{
+			name: "mounts entry with escaped paths",
+			data: `//host/my\040share /mnt/my\040share\134x cifs rw 0 0`,
+			want: &procMount{
+				device:  "//host/my share",
+				mount:   `/mnt/my share\x`,
+				fsType:  "cifs",
+				options: &mountOptions{"rw": ""},
+			},
+		},
+		{
+			name: "mountinfo entry",
+			data: "26 22 0:45 / /mnt/share rw,relatime shared:12 master:3 - cifs //host/share rw,vers=3.0",
+			want: &procMount{
+				info:         true,
+				id:           26,
+				parentID:     22,
+				majorMinor:   "0:45",
+				root:         "/",
+				device:       "//host/share",
+				mount:        "/mnt/share",
+				fsType:       "cifs",
+				options:      &mountOptions{"rw": "", "relatime": ""},
+				optional:     []string{"shared:12", "master:3"},
+				superOptions: &mountOptions{"rw": "", "vers": "3.0"},
+			},
+		},
+		{
+			name: "mountinfo entry with escaped paths",
+			data: `27 22 0:46 /sub\134dir /mnt/my\040share rw - cifs //host/my\040share rw`,
+			want: &procMount{
+				info:         true,
+				id:           27,
+				parentID:     22,
+				majorMinor:   "0:46",
+				root:         `/sub\dir`,
+				device:       "//host/my share",
+				mount:        "/mnt/my share",
+				fsType:       "cifs",
+				options:      &mountOptions{"rw": ""},
+				superOptions: &mountOptions{"rw": ""},
+			},
+		},
+		{
+			name:    "missing fields",
+			data:    "//host/share /mnt/share cifs",
+			wantErr: true,
+		},
+		{
+			name:    "invalid dump",
+			data:    "//host/share /mnt/share cifs rw x 0",
+			wantErr: true,
+		},
+		{
+			name:    "invalid escape",
+			data:    `//host/share /mnt/share\04 cifs rw 0 0`,
+			wantErr: true,
+		},
+		{
+			name:    "missing mountinfo fields after separator",
+			data:    "26 22 0:45 / /mnt/share rw shared:12 master:3 - cifs",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewProcMount([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewProcMount() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewProcMount() = %#+v, want %#+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcMount_MarshalText(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{
+			name: "mounts entry",
+			data: "//host/share /mnt/share cifs vers=3.0,rw 0 0",
+			want: "//host/share /mnt/share cifs rw,vers=3.0 0 0",
+		},
+		{
+			name: "mounts entry with escaped paths",
+			data: `//host/my\040share /mnt/my\011share cifs rw 0 0`,
+			want: `//host/my\040share /mnt/my\011share cifs rw 0 0`,
+		},
+		{
+			name: "mountinfo entry",
+			data: "26 22 0:45 / /mnt/share rw shared:12 - cifs //host/share rw,vers=3.0",
+			want: "26 22 0:45 / /mnt/share rw shared:12 - cifs //host/share rw,vers=3.0",
+		},
+		{
+			name: "mountinfo entry with escaped paths",
+			data: `27 22 0:46 /sub\134dir /mnt/my\040share rw - cifs //host/my\040share rw`,
+			want: `27 22 0:46 /sub\134dir /mnt/my\040share rw - cifs //host/my\040share rw`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mount, err := NewProcMount([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := mount.String(); got != tt.want {
+				t.Errorf("procMount.String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMountsCache_fixtures(t *testing.T) {
+	tests := []struct {
+		name       string
+		fixture    string
+		wantCifs   []string
+		wantDevice map[string]string
+	}{
+		{
+			name:     "mounts",
+			fixture:  "mounts",
+			wantCifs: []string{"/var/lib/docker-volumes/bar baz", "/var/lib/docker-volumes/foo"},
+			wantDevice: map[string]string{
+				"/":                               "overlay",
+				"/etc/hosts":                      "/dev/sda1",
+				"/var/lib/docker-volumes/foo":     "//file-server/foo",
+				"/var/lib/docker-volumes/bar baz": "//file-server/bar baz",
+			},
+		},
+		{
+			name:     "mountinfo",
+			fixture:  "mountinfo",
+			wantCifs: []string{"/var/lib/docker-volumes/bar baz", "/var/lib/docker-volumes/foo"},
+			wantDevice: map[string]string{
+				"/":                               "overlay",
+				"/etc/hosts":                      "/dev/sda1",
+				"/var/lib/docker-volumes/foo":     "//file-server/foo",
+				"/var/lib/docker-volumes/bar baz": "//file-server/bar baz",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache, err := NewMountsCache(openFixture(t, tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cifsMounts := cache.ByFsType("cifs")
+			gotCifs := make([]string, 0, len(cifsMounts))
+			for _, mount := range cifsMounts {
+				gotCifs = append(gotCifs, mount.Target())
+			}
+			if !reflect.DeepEqual(gotCifs, tt.wantCifs) {
+				t.Errorf("mountsCache.ByFsType() = %v, want %v", gotCifs, tt.wantCifs)
+			}
+
+			for target, device := range tt.wantDevice {
+				mount, exists := cache.Get(target)
+				if !exists {
+					t.Errorf("mountsCache.Get(%q) not found", target)
+					continue
+				}
+				if mount.Device() != device {
+					t.Errorf("mountsCache.Get(%q).Device() = %q, want %q", target, mount.Device(), device)
+				}
+			}
+
+			if _, exists := cache.Get("/non-existent"); exists {
+				t.Errorf("mountsCache.Get() found a non-existent mount")
+			}
+
+			if !cache.IsMounted("/var/lib/docker-volumes/foo", "cifs") {
+				t.Errorf("mountsCache.IsMounted() = false, want true")
+			}
+
+			if cache.IsMounted("/proc", "cifs") {
+				t.Errorf("mountsCache.IsMounted() = true on a different type, want false")
+			}
+
+			// every entry must survive an encode and decode round-trip
+			for target, mount := range cache.mounts {
+				data, err := mount.MarshalText()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				got, err := NewProcMount(data)
+				if err != nil {
+					t.Fatalf("failed to parse %q: %v", data, err)
+				}
+
+				if !reflect.DeepEqual(got, mount) {
+					t.Errorf("round-trip of %q = %#+v, want %#+v", target, got, mount)
+				}
+			}
+		})
+	}
+}
+
+func TestMountsCache_ReadFrom(t *testing.T) {
+	data, err := os.ReadFile(path.Join("testdata", "mounts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err := NewMountsCache(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := cache.ReadFrom(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("mountsCache.ReadFrom() of unchanged content = %d, want 0", n)
+	}
+
+	changed := append(append([]byte{}, data...), []byte("tmpfs /tmp tmpfs rw 0 0\n")...)
+	n, err = cache.ReadFrom(bytes.NewReader(changed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(changed)) {
+		t.Errorf("mountsCache.ReadFrom() of changed content = %d, want %d", n, len(changed))
+	}
+	if !cache.IsMounted("/tmp", "tmpfs") {
+		t.Errorf("mountsCache.ReadFrom() did not load the new entry")
+	}
+
+	_, err = cache.ReadFrom(bytes.NewReader([]byte("invalid\n")))
+	if err == nil {
+		t.Errorf("mountsCache.ReadFrom() error = nil, want error")
+	}
+	if !cache.IsMounted("/tmp", "tmpfs") {
+		t.Errorf("mountsCache.ReadFrom() failure discarded the previous entries")
+	}
+}
+
+func TestMountsCache_Refresh(t *testing.T) {
+	cache, err := NewMountsCache(openFixture(t, "mountinfo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := cache.Refresh(path.Join("testdata", "mountinfo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed {
+		t.Errorf("mountsCache.Refresh() = true on unchanged file, want false")
+	}
+
+	changed, err = cache.Refresh(path.Join("testdata", "mounts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Errorf("mountsCache.Refresh() = false on changed file, want true")
+	}
+
+	_, err = cache.Refresh(path.Join("testdata", "non-existent"))
+	if err == nil {
+		t.Errorf("mountsCache.Refresh() error = nil, want error")
+	}
+}